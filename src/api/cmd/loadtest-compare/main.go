@@ -0,0 +1,146 @@
+// Command loadtest-compare compares two recorded func1/func2 runs and exits
+// non-zero when the candidate regressed, so it can gate a pipeline stage.
+//
+// Exit codes: 0 no regression, 1 regression detected, 2 usage or runtime error.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"api/internal/config"
+	"api/internal/loadtest"
+	"api/internal/pg_gateway"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	var (
+		baselineID  = flag.Int64("baseline", 0, "baseline run id (default: the run before the candidate)")
+		candidateID = flag.Int64("candidate", 0, "candidate run id (default: latest run of -kind)")
+		kind        = flag.String("kind", "", "run kind (func1 or func2) used to pick runs when ids are omitted")
+		label       = flag.String("label", "", "only consider runs with this label when picking runs")
+		maxDrop     = flag.Float64("max-throughput-drop", loadtest.DefaultThresholds.MaxThroughputDrop, "tolerated throughput drop as a fraction")
+		maxLatency  = flag.Float64("max-latency-increase", loadtest.DefaultThresholds.MaxLatencyIncrease, "tolerated latency percentile increase as a fraction")
+		asJSON      = flag.Bool("json", false, "print the report as JSON")
+		configPath  = flag.String("config", os.Getenv("CONFIG_FILE"), "path to the worker's YAML or TOML config file; environment variables override it")
+	)
+	flag.Parse()
+
+	if (*baselineID == 0 || *candidateID == 0) && *kind == "" {
+		fmt.Fprintln(os.Stderr, "loadtest-compare: -kind is required unless both -baseline and -candidate are set")
+		return 2
+	}
+
+	// The runs are read with the worker's own settings, so the password
+	// may come from POSTGRES_PASSWORD_FILE and TLS applies as it does there.
+	// Only the postgres section is checked: a pipeline running this need
+	// not configure RabbitMQ or Redis.
+	cfg, err := config.LoadPostgres(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest-compare: %v\n", err)
+		return 2
+	}
+	pg, err := pg_gateway.NewPGClient(pg_gateway.Config{
+		Host:        cfg.Postgres.Host,
+		Port:        cfg.Postgres.Port,
+		User:        cfg.Postgres.User,
+		Password:    cfg.Postgres.Password,
+		DBName:      cfg.Postgres.DBName,
+		SSLMode:     cfg.Postgres.SSLMode,
+		SSLRootCert: cfg.Postgres.SSLRootCert,
+		SSLCert:     cfg.Postgres.SSLCert,
+		SSLKey:      cfg.Postgres.SSLKey,
+		PingTimeout: cfg.Postgres.PingTimeout,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest-compare: %v\n", err)
+		return 2
+	}
+	defer pg.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	baseline, candidate, err := pickRuns(ctx, pg, *baselineID, *candidateID, *kind, *label)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest-compare: %v\n", err)
+		return 2
+	}
+
+	rep, err := loadtest.Compare(baseline, candidate, loadtest.Thresholds{
+		MaxThroughputDrop:  *maxDrop,
+		MaxLatencyIncrease: *maxLatency,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest-compare: %v\n", err)
+		return 2
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rep)
+	} else {
+		err = rep.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest-compare: %v\n", err)
+		return 2
+	}
+	if rep.Regressed {
+		return 1
+	}
+	return 0
+}
+
+// pickRuns resolves the runs to compare. Missing ids are filled from the
+// history of kind/label: the newest run as candidate and the run recorded
+// just before it as baseline.
+func pickRuns(ctx context.Context, pg *pg_gateway.Client, baselineID, candidateID int64, kind, label string) (*pg_gateway.LoadTestRun, *pg_gateway.LoadTestRun, error) {
+	var baseline, candidate *pg_gateway.LoadTestRun
+	var err error
+	if baselineID != 0 {
+		if baseline, err = pg.GetLoadTestRun(ctx, baselineID); err != nil {
+			return nil, nil, fmt.Errorf("baseline run %d: %w", baselineID, err)
+		}
+	}
+	if candidateID != 0 {
+		if candidate, err = pg.GetLoadTestRun(ctx, candidateID); err != nil {
+			return nil, nil, fmt.Errorf("candidate run %d: %w", candidateID, err)
+		}
+	}
+	if baseline != nil && candidate != nil {
+		return baseline, candidate, nil
+	}
+
+	runs, err := pg.ListLoadTestRuns(ctx, pg_gateway.LoadTestRunFilter{Kind: kind, Label: label, Limit: 1000})
+	if err != nil {
+		return nil, nil, fmt.Errorf("list runs: %w", err)
+	}
+	if candidate == nil {
+		if len(runs) == 0 {
+			return nil, nil, fmt.Errorf("no %s runs recorded", kind)
+		}
+		candidate = &runs[0]
+	}
+	if baseline == nil {
+		for i := range runs {
+			if runs[i].ID != candidate.ID && runs[i].StartedAt.Before(candidate.StartedAt) {
+				baseline = &runs[i]
+				break
+			}
+		}
+		if baseline == nil {
+			return nil, nil, fmt.Errorf("no baseline run found before run %d", candidate.ID)
+		}
+	}
+	return baseline, candidate, nil
+}
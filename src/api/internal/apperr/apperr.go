@@ -0,0 +1,166 @@
+// Package apperr is the error model shared by the gateways, the worker and
+// the HTTP API. Gateways translate driver errors into an *Error of a Kind;
+// callers branch on the Kind with errors.Is instead of matching driver
+// messages, and map it to status codes, ack decisions and metric labels.
+package apperr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"api/internal/breaker"
+)
+
+// Kind classifies an error by what the caller should do about it. A Kind
+// is itself an error, so errors.Is(err, apperr.NotFound) matches any
+// *Error of that kind.
+type Kind int
+
+const (
+	// Internal is anything unclassified.
+	Internal Kind = iota
+	NotFound
+	Conflict
+	Validation
+	// Unavailable means a dependency is down or refusing work; trying
+	// again later may succeed.
+	Unavailable
+	Timeout
+	// RateLimited means the caller is over its quota; it may retry once
+	// the limit allows.
+	RateLimited
+)
+
+// Code is the Kind's stable name, used in API responses and metric labels.
+func (k Kind) Code() string {
+	switch k {
+	case NotFound:
+		return "not_found"
+	case Conflict:
+		return "conflict"
+	case Validation:
+		return "validation"
+	case Unavailable:
+		return "unavailable"
+	case Timeout:
+		return "timeout"
+	case RateLimited:
+		return "rate_limited"
+	}
+	return "internal"
+}
+
+func (k Kind) Error() string {
+	return k.Code()
+}
+
+type Error struct {
+	Kind Kind
+	// Op names the operation that failed, e.g. "pg.SaveUser".
+	Op string
+	// Msg describes the failure when there is no underlying error, or
+	// adds to it.
+	Msg string
+	Err error
+}
+
+func (e *Error) Error() string {
+	s := e.Op
+	add := func(part string) {
+		if s != "" {
+			s += ": "
+		}
+		s += part
+	}
+	if e.Msg != "" {
+		add(e.Msg)
+	}
+	if e.Err != nil {
+		add(e.Err.Error())
+	}
+	if s == "" {
+		s = e.Kind.Code()
+	}
+	return s
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the error's Kind, so errors.Is(err, apperr.Conflict) holds.
+func (e *Error) Is(target error) bool {
+	k, ok := target.(Kind)
+	return ok && k == e.Kind
+}
+
+// New returns an error of kind with no underlying cause, for sentinels.
+func New(kind Kind, msg string) *Error {
+	return &Error{Kind: kind, Msg: msg}
+}
+
+// E wraps err as kind. A nil err stays nil.
+func E(kind Kind, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+// KindOf returns the Kind of the first *Error in err's chain. Errors that
+// were never translated are classified by what they wrap: an open circuit
+// is Unavailable and an expired deadline is a Timeout.
+func KindOf(err error) Kind {
+	var e *Error
+	switch {
+	case err == nil:
+		return Internal
+	case errors.As(err, &e):
+		return e.Kind
+	case errors.Is(err, breaker.ErrCircuitOpen):
+		return Unavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return Timeout
+	}
+	return Internal
+}
+
+// Code is KindOf(err).Code(), or "ok" for nil.
+func Code(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return KindOf(err).Code()
+}
+
+// HTTPStatus maps err to a response status.
+func HTTPStatus(err error) int {
+	switch KindOf(err) {
+	case NotFound:
+		return http.StatusNotFound
+	case Conflict:
+		return http.StatusConflict
+	case Validation:
+		return http.StatusUnprocessableEntity
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	case Timeout:
+		return http.StatusGatewayTimeout
+	case RateLimited:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// Requeue reports whether a delivery that failed with err should go back
+// on the queue. NotFound, Conflict and Validation will fail the same way
+// every time, so they are rejected; anything else, including unclassified
+// errors, is requeued.
+func Requeue(err error) bool {
+	switch KindOf(err) {
+	case NotFound, Conflict, Validation:
+		return false
+	}
+	return true
+}
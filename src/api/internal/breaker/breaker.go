@@ -0,0 +1,281 @@
+// Package breaker implements a circuit breaker for calls to a dependency.
+//
+// A closed breaker counts outcomes in fixed windows and opens once the
+// failure ratio crosses a threshold. While open every call is rejected with
+// ErrCircuitOpen. After CoolDown it turns half-open and lets a few probe
+// calls through: if they succeed it closes, otherwise it opens again.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"api/internal/logging"
+	"api/internal/metrics"
+)
+
+// ErrCircuitOpen is returned, wrapped with the breaker's name, for calls
+// rejected while the breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+type State int
+
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half_open"
+	case Open:
+		return "open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+type Config struct {
+	Name string
+	// FailureRatio of calls in a window that opens the breaker. Defaults to 0.5.
+	FailureRatio float64
+	// MinRequests in a window before FailureRatio is considered. Defaults to 10.
+	MinRequests int
+	// Window is how long outcomes are counted before the counts reset.
+	// Defaults to 10s.
+	Window time.Duration
+	// CoolDown is how long the breaker stays open. Defaults to 30s.
+	CoolDown time.Duration
+	// HalfOpenRequests is how many probe calls may run while half-open, and
+	// how many must succeed to close. Defaults to 1.
+	HalfOpenRequests int
+	// IsFailure decides whether a call's error counts against the
+	// dependency. Defaults to any error except context.Canceled, which
+	// means the caller gave up rather than the dependency failing.
+	IsFailure func(error) bool
+
+	Logger *slog.Logger
+}
+
+type Breaker struct {
+	cfg     Config
+	metrics *metrics.Registry
+	log     *slog.Logger
+
+	mu          sync.Mutex
+	state       State
+	windowStart time.Time
+	requests    int
+	failures    int
+	probes      int
+	successes   int
+	listeners   []func(from, to State)
+	// changed is closed, and replaced, on every transition.
+	changed chan struct{}
+}
+
+func New(cfg Config) *Breaker {
+	if cfg.FailureRatio <= 0 {
+		cfg.FailureRatio = 0.5
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.CoolDown <= 0 {
+		cfg.CoolDown = 30 * time.Second
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = DefaultIsFailure
+	}
+	return &Breaker{
+		cfg:         cfg,
+		log:         logging.Component(cfg.Logger, "breaker").With("breaker", cfg.Name),
+		windowStart: time.Now(),
+		changed:     make(chan struct{}),
+	}
+}
+
+// DefaultIsFailure counts every error except context.Canceled.
+func DefaultIsFailure(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled)
+}
+
+func (b *Breaker) SetMetricsRegistry(reg *metrics.Registry) {
+	b.mu.Lock()
+	b.metrics = reg
+	st := b.state
+	b.mu.Unlock()
+	b.setGauge(st)
+}
+
+// OnStateChange registers fn to be called after every transition. It runs
+// on the goroutine that caused the transition and must not block.
+func (b *Breaker) OnStateChange(fn func(from, to State)) {
+	b.mu.Lock()
+	b.listeners = append(b.listeners, fn)
+	b.mu.Unlock()
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Changed returns a channel that is closed at the breaker's next
+// transition, for callers that wait out a rejection rather than retry at
+// once.
+func (b *Breaker) Changed() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.changed
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be
+// followed by exactly one Record with its outcome.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	switch b.state {
+	case Open:
+		b.mu.Unlock()
+		b.reject()
+		return fmt.Errorf("%s: %w", b.cfg.Name, ErrCircuitOpen)
+	case HalfOpen:
+		if b.probes >= b.cfg.HalfOpenRequests {
+			b.mu.Unlock()
+			b.reject()
+			return fmt.Errorf("%s: %w", b.cfg.Name, ErrCircuitOpen)
+		}
+		b.probes++
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+// Record reports the outcome of a call admitted by Allow.
+func (b *Breaker) Record(err error) {
+	failed := b.cfg.IsFailure(err)
+	neutral := err != nil && !failed
+
+	b.mu.Lock()
+	var from, to State
+	changed := false
+	switch b.state {
+	case Closed:
+		if neutral {
+			break
+		}
+		if now := time.Now(); now.Sub(b.windowStart) >= b.cfg.Window {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.cfg.MinRequests && float64(b.failures)/float64(b.requests) >= b.cfg.FailureRatio {
+			from, to, changed = b.state, Open, true
+			b.open()
+		}
+	case HalfOpen:
+		if b.probes > 0 {
+			b.probes--
+		}
+		switch {
+		case failed:
+			from, to, changed = b.state, Open, true
+			b.open()
+		case !neutral:
+			b.successes++
+			if b.successes >= b.cfg.HalfOpenRequests {
+				from, to, changed = b.state, Closed, true
+				b.state = Closed
+				b.windowStart, b.requests, b.failures = time.Now(), 0, 0
+				b.signal()
+			}
+		}
+	}
+	listeners := b.listeners
+	b.mu.Unlock()
+
+	if changed {
+		b.transitioned(from, to, listeners)
+	}
+}
+
+// open must be called with mu held. It schedules the move to half-open.
+func (b *Breaker) open() {
+	b.state = Open
+	b.probes, b.successes = 0, 0
+	b.signal()
+	time.AfterFunc(b.cfg.CoolDown, b.halfOpen)
+}
+
+// signal wakes Changed's waiters. It must be called with mu held.
+func (b *Breaker) signal() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *Breaker) halfOpen() {
+	b.mu.Lock()
+	if b.state != Open {
+		b.mu.Unlock()
+		return
+	}
+	b.state = HalfOpen
+	b.probes, b.successes = 0, 0
+	b.signal()
+	listeners := b.listeners
+	b.mu.Unlock()
+	b.transitioned(Open, HalfOpen, listeners)
+}
+
+func (b *Breaker) transitioned(from, to State, listeners []func(from, to State)) {
+	if to == Open {
+		b.log.Warn("Circuit opened", "from", from.String(), "cool_down", b.cfg.CoolDown)
+	} else {
+		b.log.Info("Circuit state changed", "from", from.String(), "to", to.String())
+	}
+	b.setGauge(to)
+	if reg := b.registry(); reg != nil {
+		reg.IncrementCounter("circuit_breaker_transitions_total", map[string]string{
+			"breaker": b.cfg.Name,
+			"to":      to.String(),
+		})
+	}
+	for _, fn := range listeners {
+		fn(from, to)
+	}
+}
+
+func (b *Breaker) reject() {
+	if reg := b.registry(); reg != nil {
+		reg.IncrementCounter("circuit_breaker_rejected_total", map[string]string{"breaker": b.cfg.Name})
+	}
+}
+
+func (b *Breaker) registry() *metrics.Registry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.metrics
+}
+
+// setGauge exports the state as 0 (closed), 1 (half-open) or 2 (open).
+func (b *Breaker) setGauge(st State) {
+	if reg := b.registry(); reg != nil {
+		reg.SetGauge("circuit_breaker_state", float64(st), map[string]string{"breaker": b.cfg.Name})
+	}
+}
@@ -0,0 +1,238 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("down")
+
+const coolDown = 10 * time.Millisecond
+
+// recorder collects the transitions reported to OnStateChange.
+type recorder struct {
+	mu   sync.Mutex
+	seen [][2]State
+}
+
+func (r *recorder) add(from, to State) {
+	r.mu.Lock()
+	r.seen = append(r.seen, [2]State{from, to})
+	r.mu.Unlock()
+}
+
+func (r *recorder) transitions() [][2]State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][2]State(nil), r.seen...)
+}
+
+func newBreaker(t *testing.T, halfOpen int) (*Breaker, *recorder) {
+	t.Helper()
+	b := New(Config{Name: "test", MinRequests: 4, FailureRatio: 0.5, Window: time.Minute, CoolDown: coolDown, HalfOpenRequests: halfOpen})
+	rec := &recorder{}
+	b.OnStateChange(rec.add)
+	return b, rec
+}
+
+// call runs one call through b with the given outcome.
+func call(t *testing.T, b *Breaker, err error) {
+	t.Helper()
+	if aerr := b.Allow(); aerr != nil {
+		t.Fatalf("Allow in state %v: %v", b.State(), aerr)
+	}
+	b.Record(err)
+}
+
+// waitHalfOpen waits out the cool-down and, if rec is non-nil, for its
+// listener: the timer's goroutine runs it after the state has changed.
+func waitHalfOpen(t *testing.T, b *Breaker, rec *recorder) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for b.State() != HalfOpen {
+		select {
+		case <-b.Changed():
+		case <-deadline:
+			t.Fatalf("breaker stayed %v, want half_open after the cool-down", b.State())
+		}
+	}
+	for rec != nil {
+		seen := rec.transitions()
+		if len(seen) > 0 && seen[len(seen)-1] == [2]State{Open, HalfOpen} {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatal("OnStateChange not called for open -> half_open")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	tests := []struct {
+		name     string
+		halfOpen int
+		probes   []error
+		want     [][2]State
+	}{
+		{
+			name:     "probe succeeds",
+			halfOpen: 1,
+			probes:   []error{nil},
+			want:     [][2]State{{Closed, Open}, {Open, HalfOpen}, {HalfOpen, Closed}},
+		},
+		{
+			name:     "every probe must succeed",
+			halfOpen: 3,
+			probes:   []error{nil, nil, nil},
+			want:     [][2]State{{Closed, Open}, {Open, HalfOpen}, {HalfOpen, Closed}},
+		},
+		{
+			name:     "probe fails",
+			halfOpen: 2,
+			probes:   []error{nil, errDown},
+			want:     [][2]State{{Closed, Open}, {Open, HalfOpen}, {HalfOpen, Open}},
+		},
+		{
+			name:     "cancelled probe decides nothing",
+			halfOpen: 1,
+			probes:   []error{context.Canceled, nil},
+			want:     [][2]State{{Closed, Open}, {Open, HalfOpen}, {HalfOpen, Closed}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, rec := newBreaker(t, tt.halfOpen)
+
+			// Under MinRequests nothing trips, whatever the ratio.
+			for i := 0; i < 3; i++ {
+				call(t, b, errDown)
+			}
+			if b.State() != Closed {
+				t.Fatalf("state = %v after 3 of MinRequests 4, want closed", b.State())
+			}
+			call(t, b, nil)
+			if b.State() != Open {
+				t.Fatalf("state = %v at 3/4 failures, want open", b.State())
+			}
+			if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("Allow while open = %v, want ErrCircuitOpen", err)
+			}
+
+			waitHalfOpen(t, b, rec)
+			for _, err := range tt.probes {
+				call(t, b, err)
+			}
+			if got := rec.transitions(); !equal(got, tt.want) {
+				t.Errorf("transitions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeutralErrorsDoNotTrip(t *testing.T) {
+	b, rec := newBreaker(t, 1)
+	for i := 0; i < 10; i++ {
+		call(t, b, context.Canceled)
+	}
+	if b.State() != Closed || len(rec.transitions()) != 0 {
+		t.Errorf("state = %v after cancellations, want closed", b.State())
+	}
+}
+
+func TestHalfOpenProbeLimit(t *testing.T) {
+	b, _ := newBreaker(t, 2)
+	for i := 0; i < 4; i++ {
+		call(t, b, errDown)
+	}
+	waitHalfOpen(t, b, nil)
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("probe %d rejected: %v", i+1, err)
+		}
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow beyond the probe budget = %v, want ErrCircuitOpen", err)
+	}
+
+	// A finished probe frees its slot; the breaker closes only after
+	// HalfOpenRequests successes.
+	b.Record(nil)
+	if b.State() != HalfOpen {
+		t.Fatalf("state = %v after 1 of 2 successes, want half_open", b.State())
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow after a probe finished: %v", err)
+	}
+	b.Record(nil)
+	b.Record(nil)
+	if b.State() != Closed {
+		t.Errorf("state = %v after 2 successes, want closed", b.State())
+	}
+}
+
+func TestChangedFiresOncePerTransition(t *testing.T) {
+	b, _ := newBreaker(t, 1)
+
+	ch := b.Changed()
+	for i := 0; i < 3; i++ {
+		call(t, b, errDown)
+	}
+	assertOpen(t, ch, "before any transition")
+
+	call(t, b, errDown) // closed -> open
+	assertClosed(t, ch, "closed -> open")
+	opened := b.Changed()
+	if opened == ch {
+		t.Fatal("Changed returned the spent channel after a transition")
+	}
+	// Rejections are not transitions.
+	_ = b.Allow()
+	assertOpen(t, opened, "a rejected call")
+
+	waitHalfOpen(t, b, nil) // open -> half-open
+	assertClosed(t, opened, "open -> half_open")
+	halfOpen := b.Changed()
+	assertOpen(t, halfOpen, "entering half_open")
+
+	call(t, b, nil) // half-open -> closed
+	assertClosed(t, halfOpen, "half_open -> closed")
+	closed := b.Changed()
+	call(t, b, nil)
+	assertOpen(t, closed, "a success while closed")
+}
+
+func assertClosed(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	default:
+		t.Fatalf("Changed not closed by %s", what)
+	}
+}
+
+func assertOpen(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+		t.Fatalf("Changed closed by %s", what)
+	default:
+	}
+}
+
+func equal(a, b [][2]State) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,609 @@
+// Package config loads the worker's settings from an optional YAML or TOML
+// file plus environment overrides into one typed struct, validates it and
+// renders it with secrets masked.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/secrets"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the full set of worker settings. Fields tagged env can be
+// overridden by that environment variable; fields tagged secret are masked
+// by Masked.
+type Config struct {
+	Log         LogConfig         `yaml:"log" toml:"log"`
+	AMQP        AMQPConfig        `yaml:"amqp" toml:"amqp"`
+	Postgres    PostgresConfig    `yaml:"postgres" toml:"postgres"`
+	Redis       RedisConfig       `yaml:"redis" toml:"redis"`
+	Users       UsersConfig       `yaml:"users" toml:"users"`
+	HTTP        HTTPConfig        `yaml:"http" toml:"http"`
+	Metrics     MetricsConfig     `yaml:"metrics" toml:"metrics"`
+	Health      HealthConfig      `yaml:"health" toml:"health"`
+	Tracing     TracingConfig     `yaml:"tracing" toml:"tracing"`
+	Memory      MemoryConfig      `yaml:"memory" toml:"memory"`
+	Diagnostics DiagnosticsConfig `yaml:"diagnostics" toml:"diagnostics"`
+	Profiling   ProfilingConfig   `yaml:"profiling" toml:"profiling"`
+	LoadTest    LoadTestConfig    `yaml:"load_test" toml:"load_test"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit" toml:"rate_limit"`
+	Secrets     SecretsConfig     `yaml:"secrets" toml:"secrets"`
+
+	// secretFiles maps a secret's path (e.g. "postgres.password") to the
+	// file it was read from via its *_FILE variable.
+	secretFiles map[string]string
+}
+
+type LogConfig struct {
+	Level            string        `yaml:"level" toml:"level" env:"LOG_LEVEL"`
+	Format           string        `yaml:"format" toml:"format" env:"LOG_FORMAT"`
+	RedactRules      string        `yaml:"redact_rules" toml:"redact_rules" env:"LOG_REDACT_RULES"`
+	RedactKey        string        `yaml:"redact_key" toml:"redact_key" env:"LOG_REDACT_KEY" secret:"true"`
+	SampleInterval   time.Duration `yaml:"sample_interval" toml:"sample_interval" env:"LOG_SAMPLE_INTERVAL"`
+	SampleFirst      int           `yaml:"sample_first" toml:"sample_first" env:"LOG_SAMPLE_FIRST"`
+	SampleThereafter int           `yaml:"sample_thereafter" toml:"sample_thereafter" env:"LOG_SAMPLE_THEREAFTER"`
+}
+
+type AMQPConfig struct {
+	URL      string `yaml:"url" toml:"url" env:"RABBITMQ_URL" secret:"url"`
+	Queue    string `yaml:"queue" toml:"queue" env:"RABBITMQ_QUEUE"`
+	Prefetch int    `yaml:"prefetch" toml:"prefetch" env:"RABBITMQ_PREFETCH"`
+	// Concurrency is how many deliveries are handled at once.
+	Concurrency int `yaml:"concurrency" toml:"concurrency" env:"RABBITMQ_CONCURRENCY"`
+	// HandlerTimeout bounds the handling of one delivery; shutdown waits
+	// for handlers rather than cancelling them.
+	HandlerTimeout time.Duration `yaml:"handler_timeout" toml:"handler_timeout" env:"RABBITMQ_HANDLER_TIMEOUT"`
+	// TLS settings, used when URL is amqps://; see tlsconfig.Config.
+	TLSCAFile     string `yaml:"tls_ca_file" toml:"tls_ca_file" env:"RABBITMQ_TLS_CA_FILE"`
+	TLSCertFile   string `yaml:"tls_cert_file" toml:"tls_cert_file" env:"RABBITMQ_TLS_CERT_FILE"`
+	TLSKeyFile    string `yaml:"tls_key_file" toml:"tls_key_file" env:"RABBITMQ_TLS_KEY_FILE"`
+	TLSServerName string `yaml:"tls_server_name" toml:"tls_server_name" env:"RABBITMQ_TLS_SERVER_NAME"`
+	TLSVerify     string `yaml:"tls_verify" toml:"tls_verify" env:"RABBITMQ_TLS_VERIFY"`
+}
+
+type PostgresConfig struct {
+	Host            string        `yaml:"host" toml:"host" env:"POSTGRES_HOST"`
+	Port            string        `yaml:"port" toml:"port" env:"POSTGRES_PORT"`
+	User            string        `yaml:"user" toml:"user" env:"POSTGRES_USER"`
+	Password        string        `yaml:"password" toml:"password" env:"POSTGRES_PASSWORD" secret:"true"`
+	DBName          string        `yaml:"dbname" toml:"dbname" env:"POSTGRES_DB"`
+	SSLMode         string        `yaml:"sslmode" toml:"sslmode" env:"POSTGRES_SSLMODE"`
+	SSLRootCert     string        `yaml:"sslrootcert" toml:"sslrootcert" env:"POSTGRES_SSLROOTCERT"`
+	SSLCert         string        `yaml:"sslcert" toml:"sslcert" env:"POSTGRES_SSLCERT"`
+	SSLKey          string        `yaml:"sslkey" toml:"sslkey" env:"POSTGRES_SSLKEY"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"POSTGRES_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"POSTGRES_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"POSTGRES_CONN_MAX_LIFETIME"`
+	PingTimeout     time.Duration `yaml:"ping_timeout" toml:"ping_timeout" env:"POSTGRES_PING_TIMEOUT"`
+	QueryTimeout    time.Duration `yaml:"query_timeout" toml:"query_timeout" env:"POSTGRES_QUERY_TIMEOUT"`
+	ExecTimeout     time.Duration `yaml:"exec_timeout" toml:"exec_timeout" env:"POSTGRES_EXEC_TIMEOUT"`
+	// Circuit breaker around queries; see breaker.Config.
+	BreakerFailureRatio float64       `yaml:"breaker_failure_ratio" toml:"breaker_failure_ratio" env:"POSTGRES_BREAKER_FAILURE_RATIO"`
+	BreakerMinRequests  int           `yaml:"breaker_min_requests" toml:"breaker_min_requests" env:"POSTGRES_BREAKER_MIN_REQUESTS"`
+	BreakerWindow       time.Duration `yaml:"breaker_window" toml:"breaker_window" env:"POSTGRES_BREAKER_WINDOW"`
+	BreakerCoolDown     time.Duration `yaml:"breaker_cool_down" toml:"breaker_cool_down" env:"POSTGRES_BREAKER_COOL_DOWN"`
+	// Retries of transient failures with jittered exponential backoff.
+	RetryMaxAttempts int           `yaml:"retry_max_attempts" toml:"retry_max_attempts" env:"POSTGRES_RETRY_MAX_ATTEMPTS"`
+	RetryBaseDelay   time.Duration `yaml:"retry_base_delay" toml:"retry_base_delay" env:"POSTGRES_RETRY_BASE_DELAY"`
+	RetryMaxDelay    time.Duration `yaml:"retry_max_delay" toml:"retry_max_delay" env:"POSTGRES_RETRY_MAX_DELAY"`
+	// Replicas are read-only standbys as host or host:port (default port:
+	// Port). Reads go to a healthy one whose lag is within
+	// MaxReplicationLag, or to the primary if there is none.
+	Replicas             []string      `yaml:"replicas" toml:"replicas" env:"POSTGRES_REPLICAS"`
+	MaxReplicationLag    time.Duration `yaml:"max_replication_lag" toml:"max_replication_lag" env:"POSTGRES_MAX_REPLICATION_LAG"`
+	ReplicaCheckInterval time.Duration `yaml:"replica_check_interval" toml:"replica_check_interval" env:"POSTGRES_REPLICA_CHECK_INTERVAL"`
+}
+
+type RedisConfig struct {
+	// Mode is standalone (Addr), sentinel (MasterName via the sentinels in
+	// Addrs) or cluster (seed nodes in Addrs).
+	Mode             string   `yaml:"mode" toml:"mode" env:"REDIS_MODE"`
+	Addrs            []string `yaml:"addrs" toml:"addrs" env:"REDIS_ADDRS"`
+	MasterName       string   `yaml:"master_name" toml:"master_name" env:"REDIS_MASTER_NAME"`
+	SentinelPassword string   `yaml:"sentinel_password" toml:"sentinel_password" env:"REDIS_SENTINEL_PASSWORD" secret:"true"`
+
+	Addr     string `yaml:"addr" toml:"addr" env:"REDIS_ADDR"`
+	Password string `yaml:"password" toml:"password" env:"REDIS_PASSWORD" secret:"true"`
+	// TLS settings, used when TLSEnabled; see tlsconfig.Config.
+	TLSEnabled    bool          `yaml:"tls_enabled" toml:"tls_enabled" env:"REDIS_TLS_ENABLED"`
+	TLSCAFile     string        `yaml:"tls_ca_file" toml:"tls_ca_file" env:"REDIS_TLS_CA_FILE"`
+	TLSCertFile   string        `yaml:"tls_cert_file" toml:"tls_cert_file" env:"REDIS_TLS_CERT_FILE"`
+	TLSKeyFile    string        `yaml:"tls_key_file" toml:"tls_key_file" env:"REDIS_TLS_KEY_FILE"`
+	TLSServerName string        `yaml:"tls_server_name" toml:"tls_server_name" env:"REDIS_TLS_SERVER_NAME"`
+	TLSVerify     string        `yaml:"tls_verify" toml:"tls_verify" env:"REDIS_TLS_VERIFY"`
+	DB            int           `yaml:"db" toml:"db" env:"REDIS_DB"`
+	DialTimeout   time.Duration `yaml:"dial_timeout" toml:"dial_timeout" env:"REDIS_DIAL_TIMEOUT"`
+	ReadTimeout   time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"REDIS_READ_TIMEOUT"`
+	WriteTimeout  time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"REDIS_WRITE_TIMEOUT"`
+	PingTimeout   time.Duration `yaml:"ping_timeout" toml:"ping_timeout" env:"REDIS_PING_TIMEOUT"`
+	OpTimeout     time.Duration `yaml:"op_timeout" toml:"op_timeout" env:"REDIS_OP_TIMEOUT"`
+	DefaultTTL    time.Duration `yaml:"default_ttl" toml:"default_ttl" env:"REDIS_DEFAULT_TTL"`
+	// ReconnectInterval paces reconnect attempts while Redis is down.
+	ReconnectInterval time.Duration `yaml:"reconnect_interval" toml:"reconnect_interval" env:"REDIS_RECONNECT_INTERVAL"`
+	// Circuit breaker around commands; see breaker.Config.
+	BreakerFailureRatio float64       `yaml:"breaker_failure_ratio" toml:"breaker_failure_ratio" env:"REDIS_BREAKER_FAILURE_RATIO"`
+	BreakerMinRequests  int           `yaml:"breaker_min_requests" toml:"breaker_min_requests" env:"REDIS_BREAKER_MIN_REQUESTS"`
+	BreakerWindow       time.Duration `yaml:"breaker_window" toml:"breaker_window" env:"REDIS_BREAKER_WINDOW"`
+	BreakerCoolDown     time.Duration `yaml:"breaker_cool_down" toml:"breaker_cool_down" env:"REDIS_BREAKER_COOL_DOWN"`
+	// Retries of transient failures with jittered exponential backoff.
+	RetryMaxAttempts int           `yaml:"retry_max_attempts" toml:"retry_max_attempts" env:"REDIS_RETRY_MAX_ATTEMPTS"`
+	RetryBaseDelay   time.Duration `yaml:"retry_base_delay" toml:"retry_base_delay" env:"REDIS_RETRY_BASE_DELAY"`
+	RetryMaxDelay    time.Duration `yaml:"retry_max_delay" toml:"retry_max_delay" env:"REDIS_RETRY_MAX_DELAY"`
+}
+
+type UsersConfig struct {
+	CacheTTL time.Duration `yaml:"cache_ttl" toml:"cache_ttl" env:"USERS_CACHE_TTL"`
+	// StaleTTL is how long past CacheTTL an entry may be served while it
+	// is refreshed; 0 disables stale-while-revalidate.
+	StaleTTL time.Duration `yaml:"stale_ttl" toml:"stale_ttl" env:"USERS_STALE_TTL"`
+	// EarlyExpirationBeta scales how early hot entries are refreshed
+	// before CacheTTL; 0 disables early expiration.
+	EarlyExpirationBeta float64 `yaml:"early_expiration_beta" toml:"early_expiration_beta" env:"USERS_EARLY_EXPIRATION_BETA"`
+	// LocalCacheMaxBytes bounds the in-process cache in front of Redis; 0
+	// disables it. Entries live at most LocalCacheTTL.
+	LocalCacheMaxBytes int64         `yaml:"local_cache_max_bytes" toml:"local_cache_max_bytes" env:"USERS_LOCAL_CACHE_MAX_BYTES"`
+	LocalCacheTTL      time.Duration `yaml:"local_cache_ttl" toml:"local_cache_ttl" env:"USERS_LOCAL_CACHE_TTL"`
+}
+
+// HTTPConfig is the listener serving /metrics and the load test API.
+type HTTPConfig struct {
+	Addr              string        `yaml:"addr" toml:"addr" env:"HTTP_ADDR"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT"`
+}
+
+type MetricsConfig struct {
+	Interval time.Duration `yaml:"interval" toml:"interval" env:"METRICS_INTERVAL"`
+}
+
+// HealthConfig tunes the /readyz dependency checks.
+type HealthConfig struct {
+	CheckTimeout time.Duration `yaml:"check_timeout" toml:"check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	CacheTTL     time.Duration `yaml:"cache_ttl" toml:"cache_ttl" env:"HEALTH_CACHE_TTL"`
+}
+
+type TracingConfig struct {
+	Exporter     string  `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint" toml:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	OTLPInsecure bool    `yaml:"otlp_insecure" toml:"otlp_insecure" env:"TRACING_OTLP_INSECURE"`
+	File         string  `yaml:"file" toml:"file" env:"TRACING_FILE"`
+	SampleRatio  float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+	ServiceName  string  `yaml:"service_name" toml:"service_name" env:"TRACING_SERVICE_NAME"`
+}
+
+type MemoryConfig struct {
+	SoftLimitBytes int64 `yaml:"soft_limit_bytes" toml:"soft_limit_bytes" env:"MEMORY_SOFT_LIMIT_BYTES"`
+	// SetRuntimeLimit also hands the soft limit to the Go runtime. Off by
+	// default: it only makes sense once a limit is known.
+	SetRuntimeLimit bool `yaml:"set_runtime_limit" toml:"set_runtime_limit" env:"MEMORY_SET_RUNTIME_LIMIT"`
+}
+
+// DiagnosticsConfig controls the authenticated pprof listener; it is
+// disabled while Token is empty.
+type DiagnosticsConfig struct {
+	Addr             string        `yaml:"addr" toml:"addr" env:"DIAG_ADDR"`
+	Token            string        `yaml:"token" toml:"token" env:"DIAG_TOKEN" secret:"true"`
+	ProfileDir       string        `yaml:"profile_dir" toml:"profile_dir" env:"DIAG_PROFILE_DIR"`
+	MaxTraceDuration time.Duration `yaml:"max_trace_duration" toml:"max_trace_duration" env:"DIAG_MAX_TRACE_DURATION"`
+}
+
+type ProfilingConfig struct {
+	Enabled            bool          `yaml:"enabled" toml:"enabled" env:"PROFILE_ENABLED"`
+	Dir                string        `yaml:"dir" toml:"dir" env:"PROFILE_DIR"`
+	Interval           time.Duration `yaml:"interval" toml:"interval" env:"PROFILE_INTERVAL"`
+	MaxBytes           int64         `yaml:"max_bytes" toml:"max_bytes" env:"PROFILE_MAX_BYTES"`
+	CPUThreshold       float64       `yaml:"cpu_threshold" toml:"cpu_threshold" env:"PROFILE_CPU_THRESHOLD"`
+	GoroutineThreshold int           `yaml:"goroutine_threshold" toml:"goroutine_threshold" env:"PROFILE_GOROUTINE_THRESHOLD"`
+}
+
+// LoadTestConfig holds the defaults used when a load test request leaves a
+// setting unset.
+type LoadTestConfig struct {
+	Func1TotalKeys int           `yaml:"func1_total_keys" toml:"func1_total_keys" env:"LOADTEST_FUNC1_TOTAL_KEYS"`
+	Func1ValueSize int           `yaml:"func1_value_size" toml:"func1_value_size" env:"LOADTEST_FUNC1_VALUE_SIZE"`
+	Func1KeyTTL    time.Duration `yaml:"func1_key_ttl" toml:"func1_key_ttl" env:"LOADTEST_FUNC1_KEY_TTL"`
+	// Func1HashTags is how many Redis Cluster hash tags func1 spreads its
+	// keys over; 0 writes untagged keys.
+	Func1HashTags  int `yaml:"func1_hash_tags" toml:"func1_hash_tags" env:"LOADTEST_FUNC1_HASH_TAGS"`
+	Func2ConnCount int `yaml:"func2_conn_count" toml:"func2_conn_count" env:"LOADTEST_FUNC2_CONN_COUNT"`
+	// Max* cap what a run request may ask for; larger requests get 422.
+	MaxTotalKeys  int   `yaml:"max_total_keys" toml:"max_total_keys" env:"LOADTEST_MAX_TOTAL_KEYS"`
+	MaxValueSize  int   `yaml:"max_value_size" toml:"max_value_size" env:"LOADTEST_MAX_VALUE_SIZE"`
+	MaxTotalBytes int64 `yaml:"max_total_bytes" toml:"max_total_bytes" env:"LOADTEST_MAX_TOTAL_BYTES"`
+	MaxConnCount  int   `yaml:"max_conn_count" toml:"max_conn_count" env:"LOADTEST_MAX_CONN_COUNT"`
+}
+
+// RateLimitConfig bounds load test requests per client (HTTP*) and user
+// deliveries per queue (Worker*). A limit of 0 disables that limiter.
+type RateLimitConfig struct {
+	// Algorithm is sliding_window or token_bucket.
+	Algorithm    string        `yaml:"algorithm" toml:"algorithm" env:"RATELIMIT_ALGORITHM"`
+	HTTPLimit    int           `yaml:"http_limit" toml:"http_limit" env:"RATELIMIT_HTTP_LIMIT"`
+	HTTPWindow   time.Duration `yaml:"http_window" toml:"http_window" env:"RATELIMIT_HTTP_WINDOW"`
+	HTTPBurst    int           `yaml:"http_burst" toml:"http_burst" env:"RATELIMIT_HTTP_BURST"`
+	WorkerLimit  int           `yaml:"worker_limit" toml:"worker_limit" env:"RATELIMIT_WORKER_LIMIT"`
+	WorkerWindow time.Duration `yaml:"worker_window" toml:"worker_window" env:"RATELIMIT_WORKER_WINDOW"`
+	WorkerBurst  int           `yaml:"worker_burst" toml:"worker_burst" env:"RATELIMIT_WORKER_BURST"`
+}
+
+type SecretsConfig struct {
+	// RefreshInterval is how often secrets loaded from *_FILE variables
+	// are re-read to pick up rotations.
+	RefreshInterval time.Duration `yaml:"refresh_interval" toml:"refresh_interval" env:"SECRETS_REFRESH_INTERVAL"`
+}
+
+// Default returns the built-in settings. Credentials and the broker URL
+// have no defaults and must be supplied.
+func Default() Config {
+	return Config{
+		Log: LogConfig{
+			Level:            "info",
+			Format:           "json",
+			SampleInterval:   time.Second,
+			SampleFirst:      10,
+			SampleThereafter: 100,
+		},
+		AMQP: AMQPConfig{Queue: "user_tasks", Prefetch: 10, Concurrency: 1, HandlerTimeout: 30 * time.Second, TLSVerify: "verify-full"},
+		Postgres: PostgresConfig{
+			Host:            "localhost",
+			Port:            "5432",
+			User:            "appuser",
+			DBName:          "appdb",
+			SSLMode:         "disable",
+			MaxOpenConns:    50,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 5 * time.Minute,
+			PingTimeout:     5 * time.Second,
+			QueryTimeout:    5 * time.Second,
+			ExecTimeout:     5 * time.Second,
+
+			BreakerFailureRatio: 0.5,
+			BreakerMinRequests:  10,
+			BreakerWindow:       10 * time.Second,
+			BreakerCoolDown:     30 * time.Second,
+
+			RetryMaxAttempts: 3,
+			RetryBaseDelay:   50 * time.Millisecond,
+			RetryMaxDelay:    time.Second,
+
+			MaxReplicationLag:    10 * time.Second,
+			ReplicaCheckInterval: 5 * time.Second,
+		},
+		Redis: RedisConfig{
+			Mode:        "standalone",
+			TLSVerify:   "verify-full",
+			Addr:        "localhost:6379",
+			PingTimeout: 3 * time.Second,
+			OpTimeout:   2 * time.Second,
+
+			ReconnectInterval: 5 * time.Second,
+
+			BreakerFailureRatio: 0.5,
+			BreakerMinRequests:  20,
+			BreakerWindow:       10 * time.Second,
+			BreakerCoolDown:     15 * time.Second,
+
+			RetryMaxAttempts: 3,
+			RetryBaseDelay:   20 * time.Millisecond,
+			RetryMaxDelay:    500 * time.Millisecond,
+		},
+		Users: UsersConfig{
+			CacheTTL:            10 * time.Minute,
+			StaleTTL:            time.Minute,
+			EarlyExpirationBeta: 1,
+			LocalCacheTTL:       5 * time.Second,
+		},
+		HTTP: HTTPConfig{
+			Addr:              ":9090",
+			ReadHeaderTimeout: 10 * time.Second,
+			ShutdownTimeout:   5 * time.Second,
+		},
+		Metrics: MetricsConfig{Interval: 10 * time.Second},
+		Health:  HealthConfig{CheckTimeout: 2 * time.Second, CacheTTL: 2 * time.Second},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			File:        "traces.jsonl",
+			SampleRatio: 1,
+			ServiceName: "api-worker",
+		},
+		Diagnostics: DiagnosticsConfig{
+			Addr:             "127.0.0.1:6060",
+			MaxTraceDuration: 30 * time.Second,
+		},
+		// Continuous profiling writes to disk, so it is opt-in.
+		Profiling: ProfilingConfig{
+			Dir:                filepath.Join(os.TempDir(), "api-profiles", "continuous"),
+			Interval:           10 * time.Minute,
+			MaxBytes:           256 << 20,
+			CPUThreshold:       0.8,
+			GoroutineThreshold: 10000,
+		},
+		LoadTest: LoadTestConfig{
+			Func1TotalKeys: 5000,
+			Func1ValueSize: 4096,
+			Func2ConnCount: 50,
+			MaxTotalKeys:   100000,
+			MaxValueSize:   1 << 20,
+			MaxTotalBytes:  256 << 20,
+			MaxConnCount:   200,
+		},
+		RateLimit: RateLimitConfig{
+			Algorithm:    "token_bucket",
+			HTTPLimit:    10,
+			HTTPWindow:   time.Minute,
+			WorkerWindow: time.Second,
+		},
+		Secrets: SecretsConfig{RefreshInterval: 30 * time.Second},
+	}
+}
+
+// Load starts from Default, applies path (if non-empty) and then the
+// environment, and validates the result.
+func Load(path string) (Config, error) {
+	cfg, err := load(path)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// LoadPostgres is Load for tools that only connect to Postgres: the file,
+// environment and *_FILE secrets apply as usual, but only the postgres
+// section has to be valid.
+func LoadPostgres(path string) (Config, error) {
+	cfg, err := load(path)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := cfg.ValidatePostgres(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		// An empty document decodes to io.EOF; treat it as "no overrides".
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config: parse %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("config: parse %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("config: parse %s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("config: %s: unsupported extension, want .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// applyEnv overrides every env-tagged field whose variable is set. Secret
+// fields can instead name a file with <VAR>_FILE, which wins over <VAR>.
+// Two legacy variables are still honoured: REDIS_HOST (port 6379 implied)
+// and METRICS_PORT (the HTTP listener port).
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	walk(reflect.ValueOf(c).Elem(), "", func(f reflect.Value, sf reflect.StructField, path string) {
+		name := sf.Tag.Get("env")
+		if name == "" {
+			return
+		}
+		if sf.Tag.Get("secret") != "" {
+			if file, ok := lookup(name + "_FILE"); ok && file != "" {
+				v, err := secrets.ReadFile(file)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s_FILE: %w", name, err))
+					return
+				}
+				f.SetString(v)
+				if c.secretFiles == nil {
+					c.secretFiles = make(map[string]string)
+				}
+				c.secretFiles[path] = file
+				return
+			}
+		}
+		raw, ok := lookup(name)
+		if !ok {
+			return
+		}
+		if err := setField(f, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	})
+	if _, ok := lookup("REDIS_ADDR"); !ok {
+		if host, ok := lookup("REDIS_HOST"); ok {
+			c.Redis.Addr = net.JoinHostPort(host, "6379")
+		}
+	}
+	if _, ok := lookup("HTTP_ADDR"); !ok {
+		if port, ok := lookup("METRICS_PORT"); ok {
+			c.HTTP.Addr = ":" + port
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("config: invalid environment: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setField(f reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", f.Type())
+		}
+		// Comma-separated; empty items are dropped.
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}
+
+// walk calls fn for every leaf field of the struct v with its dotted
+// yaml path, e.g. "postgres.password".
+func walk(v reflect.Value, prefix string, fn func(f reflect.Value, sf reflect.StructField, path string)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		f := v.Field(i)
+		if f.Kind() == reflect.Struct && f.Type() != durationType {
+			walk(f, name, fn)
+			continue
+		}
+		fn(f, sf, name)
+	}
+}
+
+// SecretFile reports the file a secret (e.g. "postgres.password") was
+// loaded from, if it came from a *_FILE variable.
+func (c Config) SecretFile(path string) (string, bool) {
+	f, ok := c.secretFiles[path]
+	return f, ok
+}
+
+const maskValue = "****"
+
+// Masked returns a copy of c with secrets replaced, safe to log or print.
+// URLs keep everything except the password.
+func (c Config) Masked() Config {
+	walk(reflect.ValueOf(&c).Elem(), "", func(f reflect.Value, sf reflect.StructField, _ string) {
+		switch sf.Tag.Get("secret") {
+		case "true":
+			if f.String() != "" {
+				f.SetString(maskValue)
+			}
+		case "url":
+			f.SetString(maskURL(f.String()))
+		}
+	})
+	return c
+}
+
+func maskURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		if raw == "" {
+			return ""
+		}
+		return maskValue
+	}
+	return u.Redacted()
+}
+
+// YAML renders the masked config.
+func (c Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c.Masked())
+}
+
+// Fields flattens the masked config into dotted keys, e.g.
+// "postgres.exec_timeout", for structured logging.
+func (c Config) Fields() map[string]interface{} {
+	return c.Masked().fields()
+}
+
+func (c Config) fields() map[string]interface{} {
+	out := make(map[string]interface{})
+	walk(reflect.ValueOf(&c).Elem(), "", func(f reflect.Value, _ reflect.StructField, path string) {
+		if f.Type() == durationType {
+			out[path] = time.Duration(f.Int()).String()
+			return
+		}
+		// Slices are joined so values stay comparable (see Diff).
+		if items, ok := f.Interface().([]string); ok {
+			out[path] = strings.Join(items, ",")
+			return
+		}
+		out[path] = f.Interface()
+	})
+	return out
+}
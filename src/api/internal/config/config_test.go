@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// unsetEnv clears name for the rest of the test.
+func unsetEnv(t *testing.T, name string) {
+	t.Helper()
+	t.Setenv(name, "")
+	os.Unsetenv(name)
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPostgresWithoutAMQP(t *testing.T) {
+	for _, name := range []string{"RABBITMQ_URL", "RABBITMQ_URL_FILE", "POSTGRES_PASSWORD"} {
+		unsetEnv(t, name)
+	}
+	t.Setenv("POSTGRES_PASSWORD_FILE", writeFile(t, "pgpass", "hunter2\n"))
+	path := writeFile(t, "config.yaml", `
+postgres:
+  host: db.internal
+  user: loadtest
+  dbname: app
+`)
+
+	cfg, err := LoadPostgres(path)
+	if err != nil {
+		t.Fatalf("LoadPostgres: %v", err)
+	}
+	if cfg.Postgres.Host != "db.internal" || cfg.Postgres.Password != "hunter2" {
+		t.Errorf("postgres = %+v, want the file's host and the *_FILE password", cfg.Postgres)
+	}
+
+	// The worker itself still needs RabbitMQ.
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "amqp.url") {
+		t.Errorf("Load error = %v, want amqp.url required", err)
+	}
+}
+
+func TestLoadPostgresValidatesPostgres(t *testing.T) {
+	unsetEnv(t, "POSTGRES_PASSWORD")
+	unsetEnv(t, "POSTGRES_PASSWORD_FILE")
+	path := writeFile(t, "config.yaml", "postgres:\n  sslmode: prefer\n")
+
+	_, err := LoadPostgres(path)
+	if err == nil {
+		t.Fatal("LoadPostgres succeeded without a password")
+	}
+	for _, want := range []string{"postgres.password", "postgres.sslmode"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadPostgres error = %v, want it to mention %s", err, want)
+		}
+	}
+}
+
+func TestDefaultsLeaveOptionalFeaturesOff(t *testing.T) {
+	cfg := Default()
+	if cfg.Memory.SetRuntimeLimit {
+		t.Error("memory.set_runtime_limit defaults to true, want it set only with a limit")
+	}
+	if cfg.Profiling.Enabled {
+		t.Error("profiling.enabled defaults to true, want continuous profiling opt-in")
+	}
+	// Turning profiling on still finds usable defaults for the rest.
+	cfg.Profiling.Enabled = true
+	if err := cfg.Validate(); err != nil && strings.Contains(err.Error(), "profiling.") {
+		t.Errorf("Validate with profiling enabled: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+
+	"api/internal/logging"
+)
+
+// Reloadable lists the settings a reload applies to the running process.
+// Changes to anything else are reported but need a restart.
+var Reloadable = map[string]bool{
+	"log.level":                   true,
+	"users.cache_ttl":             true,
+	"users.stale_ttl":             true,
+	"users.early_expiration_beta": true,
+	"amqp.concurrency":            true,
+	"metrics.interval":            true,
+}
+
+// Change is one setting that differs between two configs. Secret values
+// are masked.
+type Change struct {
+	Path    string      `json:"path"`
+	Old     interface{} `json:"old"`
+	New     interface{} `json:"new"`
+	Applied bool        `json:"applied"`
+}
+
+// Diff lists the settings that differ between old and next, sorted by path.
+func Diff(old, next Config) []Change {
+	oldRaw, nextRaw := old.fields(), next.fields()
+	oldMasked, nextMasked := old.Masked().fields(), next.Masked().fields()
+
+	var changes []Change
+	for path, v := range nextRaw {
+		if oldRaw[path] == v {
+			continue
+		}
+		changes = append(changes, Change{
+			Path:    path,
+			Old:     oldMasked[path],
+			New:     nextMasked[path],
+			Applied: Reloadable[path],
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// Reloader re-reads the configuration on demand and hands the reloadable
+// subset of it to apply. An invalid file or environment leaves the running
+// config untouched.
+type Reloader struct {
+	path  string
+	apply func(Config)
+	log   *slog.Logger
+
+	mu      sync.Mutex
+	current Config
+}
+
+func NewReloader(path string, current Config, apply func(Config), logger *slog.Logger) *Reloader {
+	return &Reloader{path: path, current: current, apply: apply, log: logging.Component(logger, "config")}
+}
+
+func (r *Reloader) Current() Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload loads and validates the configuration, applies the settings in
+// Reloadable and returns every change found.
+func (r *Reloader) Reload() ([]Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := Load(r.path)
+	if err != nil {
+		r.log.Error("Configuration reload rejected", "error", err)
+		return nil, err
+	}
+
+	changes := Diff(r.current, next)
+	merged := r.current
+	applied := 0
+	for _, ch := range changes {
+		if !ch.Applied {
+			r.log.Warn("Configuration change requires a restart", "setting", ch.Path, "old", ch.Old, "new", ch.New)
+			continue
+		}
+		copyField(&merged, &next, ch.Path)
+		r.log.Info("Configuration setting changed", "setting", ch.Path, "old", ch.Old, "new", ch.New)
+		applied++
+	}
+	if applied > 0 {
+		r.apply(merged)
+		r.current = merged
+	}
+	r.log.Info("Configuration reloaded", "changes", len(changes), "applied", applied)
+	return changes, nil
+}
+
+// copyField sets the field at path in dst to its value in src.
+func copyField(dst, src *Config, path string) {
+	var from reflect.Value
+	walk(reflect.ValueOf(src).Elem(), "", func(f reflect.Value, _ reflect.StructField, p string) {
+		if p == path {
+			from = f
+		}
+	})
+	walk(reflect.ValueOf(dst).Elem(), "", func(f reflect.Value, _ reflect.StructField, p string) {
+		if p == path && from.IsValid() {
+			f.Set(from)
+		}
+	})
+}
+
+// Handler triggers a reload on POST and reports the changes as JSON.
+func (r *Reloader) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		changes, err := r.Reload()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if changes == nil {
+			changes = []Change{}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"changes": changes})
+	})
+}
@@ -0,0 +1,266 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/logging"
+)
+
+// Validate reports every problem found, one per line, so a bad deployment
+// can be fixed in a single pass.
+func (c Config) Validate() error {
+	var v validator
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		v.add("log.level", "%v", err)
+	}
+	v.oneOf("log.format", c.Log.Format, "json", "text")
+	if _, err := logging.ParseRules(c.Log.RedactRules); err != nil {
+		v.add("log.redact_rules", "%v", err)
+	}
+	v.positive("log.sample_interval", int64(c.Log.SampleInterval))
+	v.nonNegative("log.sample_first", int64(c.Log.SampleFirst))
+	v.nonNegative("log.sample_thereafter", int64(c.Log.SampleThereafter))
+
+	if c.AMQP.URL == "" {
+		v.add("amqp.url", "is required (RABBITMQ_URL or RABBITMQ_URL_FILE)")
+	} else if u, err := url.Parse(c.AMQP.URL); err != nil {
+		v.add("amqp.url", "is not a valid URL")
+	} else if u.Scheme != "amqp" && u.Scheme != "amqps" {
+		v.add("amqp.url", "scheme must be amqp or amqps, got %q", u.Scheme)
+	}
+	v.required("amqp.queue", c.AMQP.Queue)
+	v.positive("amqp.prefetch", int64(c.AMQP.Prefetch))
+	v.positive("amqp.concurrency", int64(c.AMQP.Concurrency))
+	v.positive("amqp.handler_timeout", int64(c.AMQP.HandlerTimeout))
+	v.tls("amqp", c.AMQP.TLSCertFile, c.AMQP.TLSKeyFile, c.AMQP.TLSVerify)
+
+	v.postgres(c.Postgres)
+
+	v.oneOf("redis.mode", c.Redis.Mode, "standalone", "sentinel", "cluster")
+	switch c.Redis.Mode {
+	case "standalone":
+		v.hostPort("redis.addr", c.Redis.Addr)
+	case "sentinel", "cluster":
+		if len(c.Redis.Addrs) == 0 {
+			v.add("redis.addrs", "is required in %s mode", c.Redis.Mode)
+		}
+		for _, a := range c.Redis.Addrs {
+			v.hostPort("redis.addrs", a)
+		}
+	}
+	if c.Redis.Mode == "sentinel" {
+		v.required("redis.master_name", c.Redis.MasterName)
+	}
+	if c.Redis.Mode == "cluster" && c.Redis.DB != 0 {
+		v.add("redis.db", "must be 0 in cluster mode")
+	}
+	v.nonNegative("redis.db", int64(c.Redis.DB))
+	v.tls("redis", c.Redis.TLSCertFile, c.Redis.TLSKeyFile, c.Redis.TLSVerify)
+	v.nonNegative("redis.dial_timeout", int64(c.Redis.DialTimeout))
+	v.nonNegative("redis.read_timeout", int64(c.Redis.ReadTimeout))
+	v.nonNegative("redis.write_timeout", int64(c.Redis.WriteTimeout))
+	v.positive("redis.ping_timeout", int64(c.Redis.PingTimeout))
+	v.positive("redis.op_timeout", int64(c.Redis.OpTimeout))
+	v.nonNegative("redis.default_ttl", int64(c.Redis.DefaultTTL))
+	v.positive("redis.reconnect_interval", int64(c.Redis.ReconnectInterval))
+	v.breaker("redis", c.Redis.BreakerFailureRatio, c.Redis.BreakerMinRequests, c.Redis.BreakerWindow, c.Redis.BreakerCoolDown)
+	v.retry("redis", c.Redis.RetryMaxAttempts, c.Redis.RetryBaseDelay, c.Redis.RetryMaxDelay)
+
+	v.positive("users.cache_ttl", int64(c.Users.CacheTTL))
+	v.nonNegative("users.stale_ttl", int64(c.Users.StaleTTL))
+	if c.Users.EarlyExpirationBeta < 0 {
+		v.add("users.early_expiration_beta", "must not be negative, got %g", c.Users.EarlyExpirationBeta)
+	}
+	v.nonNegative("users.local_cache_max_bytes", c.Users.LocalCacheMaxBytes)
+	if c.Users.LocalCacheMaxBytes > 0 {
+		v.positive("users.local_cache_ttl", int64(c.Users.LocalCacheTTL))
+	}
+
+	v.hostPort("http.addr", c.HTTP.Addr)
+	v.positive("http.read_header_timeout", int64(c.HTTP.ReadHeaderTimeout))
+	v.positive("http.shutdown_timeout", int64(c.HTTP.ShutdownTimeout))
+
+	v.positive("metrics.interval", int64(c.Metrics.Interval))
+
+	v.positive("health.check_timeout", int64(c.Health.CheckTimeout))
+	v.nonNegative("health.cache_ttl", int64(c.Health.CacheTTL))
+
+	v.oneOf("tracing.exporter", c.Tracing.Exporter, "none", "otlp", "stdout", "file")
+	if c.Tracing.Exporter == "file" {
+		v.required("tracing.file", c.Tracing.File)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		v.add("tracing.sample_ratio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+
+	v.nonNegative("memory.soft_limit_bytes", c.Memory.SoftLimitBytes)
+
+	if c.Diagnostics.Token != "" {
+		v.hostPort("diagnostics.addr", c.Diagnostics.Addr)
+		v.positive("diagnostics.max_trace_duration", int64(c.Diagnostics.MaxTraceDuration))
+	}
+
+	if c.Profiling.Enabled {
+		v.required("profiling.dir", c.Profiling.Dir)
+		v.positive("profiling.interval", int64(c.Profiling.Interval))
+		v.positive("profiling.max_bytes", c.Profiling.MaxBytes)
+		if c.Profiling.CPUThreshold < 0 || c.Profiling.CPUThreshold > 1 {
+			v.add("profiling.cpu_threshold", "must be between 0 and 1, got %g", c.Profiling.CPUThreshold)
+		}
+		v.nonNegative("profiling.goroutine_threshold", int64(c.Profiling.GoroutineThreshold))
+	}
+
+	v.nonNegative("load_test.func1_total_keys", int64(c.LoadTest.Func1TotalKeys))
+	v.nonNegative("load_test.func1_value_size", int64(c.LoadTest.Func1ValueSize))
+	v.nonNegative("load_test.func1_key_ttl", int64(c.LoadTest.Func1KeyTTL))
+	v.nonNegative("load_test.func1_hash_tags", int64(c.LoadTest.Func1HashTags))
+	v.nonNegative("load_test.func2_conn_count", int64(c.LoadTest.Func2ConnCount))
+	v.nonNegative("load_test.max_total_keys", int64(c.LoadTest.MaxTotalKeys))
+	v.nonNegative("load_test.max_value_size", int64(c.LoadTest.MaxValueSize))
+	v.nonNegative("load_test.max_total_bytes", c.LoadTest.MaxTotalBytes)
+	v.nonNegative("load_test.max_conn_count", int64(c.LoadTest.MaxConnCount))
+
+	v.oneOf("rate_limit.algorithm", c.RateLimit.Algorithm, "sliding_window", "token_bucket")
+	v.nonNegative("rate_limit.http_limit", int64(c.RateLimit.HTTPLimit))
+	v.nonNegative("rate_limit.http_burst", int64(c.RateLimit.HTTPBurst))
+	v.nonNegative("rate_limit.worker_limit", int64(c.RateLimit.WorkerLimit))
+	v.nonNegative("rate_limit.worker_burst", int64(c.RateLimit.WorkerBurst))
+	if c.RateLimit.HTTPLimit > 0 && c.RateLimit.HTTPWindow < time.Millisecond {
+		v.add("rate_limit.http_window", "must be at least 1ms")
+	}
+	if c.RateLimit.WorkerLimit > 0 && c.RateLimit.WorkerWindow < time.Millisecond {
+		v.add("rate_limit.worker_window", "must be at least 1ms")
+	}
+
+	v.positive("secrets.refresh_interval", int64(c.Secrets.RefreshInterval))
+
+	return v.err()
+}
+
+// ValidatePostgres checks only the postgres section, for tools that use
+// nothing else.
+func (c Config) ValidatePostgres() error {
+	var v validator
+	v.postgres(c.Postgres)
+	return v.err()
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.problems = append(v.problems, field+" "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, "is required")
+	}
+}
+
+func (v *validator) positive(field string, n int64) {
+	if n <= 0 {
+		v.add(field, "must be positive")
+	}
+}
+
+func (v *validator) nonNegative(field string, n int64) {
+	if n < 0 {
+		v.add(field, "must not be negative")
+	}
+}
+
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(field, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+}
+
+func (v *validator) hostPort(field, addr string) {
+	if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+		v.add(field, "must be host:port, got %q", addr)
+	}
+}
+
+func (v *validator) postgres(p PostgresConfig) {
+	v.required("postgres.host", p.Host)
+	if port, err := strconv.Atoi(p.Port); err != nil || port <= 0 || port > 65535 {
+		v.add("postgres.port", "must be a port number, got %q", p.Port)
+	}
+	v.required("postgres.user", p.User)
+	if p.Password == "" {
+		v.add("postgres.password", "is required (POSTGRES_PASSWORD or POSTGRES_PASSWORD_FILE)")
+	}
+	v.required("postgres.dbname", p.DBName)
+	// lib/pq supports only these; allow and prefer are rejected at dial.
+	v.oneOf("postgres.sslmode", p.SSLMode, "disable", "require", "verify-ca", "verify-full")
+	if (p.SSLCert == "") != (p.SSLKey == "") {
+		v.add("postgres.sslkey", "must be set together with postgres.sslcert")
+	}
+	v.positive("postgres.max_open_conns", int64(p.MaxOpenConns))
+	v.nonNegative("postgres.max_idle_conns", int64(p.MaxIdleConns))
+	if p.MaxIdleConns > p.MaxOpenConns {
+		v.add("postgres.max_idle_conns", "must not exceed max_open_conns (%d)", p.MaxOpenConns)
+	}
+	v.positive("postgres.conn_max_lifetime", int64(p.ConnMaxLifetime))
+	v.positive("postgres.ping_timeout", int64(p.PingTimeout))
+	v.positive("postgres.query_timeout", int64(p.QueryTimeout))
+	v.positive("postgres.exec_timeout", int64(p.ExecTimeout))
+	v.breaker("postgres", p.BreakerFailureRatio, p.BreakerMinRequests, p.BreakerWindow, p.BreakerCoolDown)
+	v.retry("postgres", p.RetryMaxAttempts, p.RetryBaseDelay, p.RetryMaxDelay)
+	for _, r := range p.Replicas {
+		if host, _, err := net.SplitHostPort(r); strings.TrimSpace(r) == "" || (err == nil && host == "") {
+			v.add("postgres.replicas", "invalid host %q", r)
+		}
+	}
+	if len(p.Replicas) > 0 {
+		v.positive("postgres.max_replication_lag", int64(p.MaxReplicationLag))
+		v.positive("postgres.replica_check_interval", int64(p.ReplicaCheckInterval))
+	}
+}
+
+// tls checks the TLS* fields of the prefix section. Files are only read
+// when the connection is made.
+func (v *validator) tls(prefix, certFile, keyFile, verify string) {
+	if (certFile == "") != (keyFile == "") {
+		v.add(prefix+".tls_key_file", "must be set together with %s.tls_cert_file", prefix)
+	}
+	v.oneOf(prefix+".tls_verify", verify, "verify-full", "verify-ca", "none")
+}
+
+// breaker checks the Breaker* fields of the prefix section.
+func (v *validator) breaker(prefix string, ratio float64, minRequests int, window, coolDown time.Duration) {
+	if ratio <= 0 || ratio > 1 {
+		v.add(prefix+".breaker_failure_ratio", "must be in (0, 1], got %g", ratio)
+	}
+	v.positive(prefix+".breaker_min_requests", int64(minRequests))
+	v.positive(prefix+".breaker_window", int64(window))
+	v.positive(prefix+".breaker_cool_down", int64(coolDown))
+}
+
+// retry checks the Retry* fields of the prefix section.
+func (v *validator) retry(prefix string, maxAttempts int, base, max time.Duration) {
+	v.positive(prefix+".retry_max_attempts", int64(maxAttempts))
+	v.positive(prefix+".retry_base_delay", int64(base))
+	if max < base {
+		v.add(prefix+".retry_max_delay", "must not be less than retry_base_delay (%v)", base)
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(v.problems, "\n  "))
+}
@@ -0,0 +1,106 @@
+// Package correlation carries a per-request ID through context.Context so
+// logs and errors from one AMQP delivery or HTTP request can be tied
+// together across the worker, UsersManager and the gateways.
+package correlation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header read from requests and echoed on responses.
+const Header = "X-Request-ID"
+
+// maxIDLen bounds caller-supplied IDs so a client cannot bloat every log line.
+const maxIDLen = 128
+
+type ctxKey struct{}
+
+// NewID returns a fresh random ID.
+func NewID() string {
+	return uuid.NewString()
+}
+
+// WithID returns ctx carrying id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// ID returns the ID carried by ctx, or "" if there is none.
+func ID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// Ensure attaches the first usable candidate to ctx, generating an ID when
+// none is. It returns the new context and the ID chosen.
+func Ensure(ctx context.Context, candidates ...string) (context.Context, string) {
+	for _, c := range candidates {
+		if c = sanitize(c); c != "" {
+			return WithID(ctx, c), c
+		}
+	}
+	id := NewID()
+	return WithID(ctx, id), id
+}
+
+func sanitize(id string) string {
+	id = strings.TrimSpace(id)
+	if len(id) > maxIDLen {
+		return ""
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return ""
+		}
+	}
+	return id
+}
+
+// Middleware takes the ID from the X-Request-ID header (generating one if
+// absent), stores it in the request context and echoes it on the response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, id := Ensure(r.Context(), r.Header.Get(Header))
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Error annotates an error with the request ID it happened under.
+type Error struct {
+	ID  string
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error() + " (request_id=" + e.ID + ")"
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Annotate wraps err with the ID carried by ctx. It is a no-op for nil
+// errors, contexts without an ID and errors that are already annotated.
+func Annotate(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	id := ID(ctx)
+	if id == "" {
+		return err
+	}
+	var ce *Error
+	if errors.As(err, &ce) {
+		return err
+	}
+	return &Error{ID: id, Err: err}
+}
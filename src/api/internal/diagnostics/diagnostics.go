@@ -0,0 +1,171 @@
+package diagnostics
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"runtime/trace"
+	"strings"
+	"time"
+
+	"api/internal/logging"
+	"api/internal/usage"
+)
+
+// Config controls the diagnostics listener. It is served separately from
+// /metrics so it can be bound to a private interface.
+type Config struct {
+	Addr             string
+	Token            string
+	ProfileDir       string
+	MaxTraceDuration time.Duration
+
+	Logger *slog.Logger
+}
+
+type Server struct {
+	cfg Config
+	mux *http.ServeMux
+	srv *http.Server
+	log *slog.Logger
+}
+
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.Token == "" {
+		return nil, errors.New("diagnostics: token is required")
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = "127.0.0.1:6060"
+	}
+	if cfg.ProfileDir == "" {
+		cfg.ProfileDir = filepath.Join(os.TempDir(), "api-profiles")
+	}
+	if cfg.MaxTraceDuration == 0 {
+		cfg.MaxTraceDuration = 30 * time.Second
+	}
+	if err := os.MkdirAll(cfg.ProfileDir, 0o750); err != nil {
+		return nil, fmt.Errorf("diagnostics: profile dir: %w", err)
+	}
+
+	s := &Server{cfg: cfg, mux: http.NewServeMux(), log: logging.Component(cfg.Logger, "diagnostics")}
+	s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.mux.HandleFunc("/debug/goroutines", s.goroutines)
+	s.mux.HandleFunc("/debug/trace", s.trace)
+	s.mux.HandleFunc("/debug/heap/snapshot", s.heapSnapshot)
+
+	s.srv = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           s.authenticate(s.mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s, nil
+}
+
+// Handle registers an additional authenticated endpoint.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+func (s *Server) ListenAndServe() error {
+	s.log.Info("Diagnostics server listening", "addr", s.cfg.Addr, "profile_dir", s.cfg.ProfileDir)
+	err := s.srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	want := []byte("Bearer " + s.cfg.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="diagnostics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// goroutines dumps every goroutine's stack in the panic-style text format.
+func (s *Server) goroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := rpprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+		s.log.Error("Failed to write goroutine dump", "error", err)
+	}
+}
+
+// trace captures a runtime execution trace for ?duration= (default 5s,
+// capped at MaxTraceDuration) and streams it back.
+func (s *Server) trace(w http.ResponseWriter, r *http.Request) {
+	d := 5 * time.Second
+	if v := r.URL.Query().Get("duration"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		d = parsed
+	}
+	if d > s.cfg.MaxTraceDuration {
+		http.Error(w, fmt.Sprintf("duration exceeds maximum of %v", s.cfg.MaxTraceDuration), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace.out"`)
+	if err := trace.Start(w); err != nil {
+		// Another trace (pprof or ours) is already running.
+		http.Error(w, "could not start trace: "+err.Error(), http.StatusConflict)
+		return
+	}
+	s.log.Info("Capturing runtime trace", "duration", d)
+	select {
+	case <-time.After(d):
+	case <-r.Context().Done():
+	}
+	trace.Stop()
+}
+
+// heapSnapshot writes a heap profile into ProfileDir. ?gc=1 forces a
+// collection first so the profile reflects live objects only.
+func (s *Server) heapSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if gc := r.URL.Query().Get("gc"); gc == "1" || strings.EqualFold(gc, "true") {
+		runtime.GC()
+	}
+
+	path, size, err := usage.WriteHeapProfile(s.cfg.ProfileDir, "snapshot")
+	if err != nil {
+		s.log.Error("Failed to write heap snapshot", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.log.Info("Heap snapshot written", "path", path, "bytes", size)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"path": path, "bytes": size})
+}
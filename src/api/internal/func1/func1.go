@@ -3,10 +3,13 @@ package func1
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
+	"sort"
 	"time"
 
+	"api/internal/logging"
+	"api/internal/metrics"
 	"api/internal/redis_gateway"
 )
 type Stats struct {
@@ -15,19 +18,36 @@ type Stats struct {
 	DurationSeconds float64       `json:"duration_seconds"`
 	KeysPerSecond   float64       `json:"keys_per_second"`
 	TotalBytes      int64         `json:"total_bytes"`
+	LatencyP50Seconds float64     `json:"latency_p50_seconds"`
+	LatencyP95Seconds float64     `json:"latency_p95_seconds"`
+	LatencyP99Seconds float64     `json:"latency_p99_seconds"`
 	Keys            []string      `json:"keys,omitempty"`
 	Values          []string      `json:"values,omitempty"`
 }
 type Func1Config struct {
-	TotalKeys      int
-	ValueSize      int
-	KeyTTL         time.Duration 
-	KeepValuesInRAM bool          
+	TotalKeys      int           `json:"total_keys"`
+	ValueSize      int           `json:"value_size"`
+	KeyTTL         time.Duration `json:"key_ttl"`
+	KeepValuesInRAM bool         `json:"keep_values_in_ram"`
+	// HashTags spreads keys round-robin over this many Redis Cluster hash
+	// tags ("{func1:N}:key:i"), so a run can target a chosen number of
+	// slots: 1 puts every key in one slot, a large value approaches the
+	// spread of untagged keys. 0 or less writes untagged keys.
+	HashTags       int           `json:"hash_tags"`
+
+	Logger *slog.Logger `json:"-"`
 }
-func Func1Run(client *redis_gateway.Client, cfg Func1Config) (*Stats, error) {
-	log.Printf("[FUNC1] Starting optimized stress test on Redis (Keys: %d, TTL: %v)", cfg.TotalKeys, cfg.KeyTTL)
 
-	
+// errorSampling keeps a Redis outage from logging every failed key: the
+// first few per interval are logged, the rest are summarised.
+var errorSampling = logging.SamplingOptions{Interval: 10 * time.Second, First: 5}
+
+func Func1Run(ctx context.Context, client *redis_gateway.Client, cfg Func1Config) (*Stats, error) {
+	if client == nil {
+		return nil, fmt.Errorf("func1: redis client is required")
+	}
+	logger := logging.Sampled(logging.Component(cfg.Logger, "func1"), errorSampling)
+
 	if cfg.TotalKeys <= 0 {
 		cfg.TotalKeys = 5000
 	}
@@ -37,6 +57,7 @@ func Func1Run(client *redis_gateway.Client, cfg Func1Config) (*Stats, error) {
 	if cfg.KeyTTL == 0 {
 		cfg.KeyTTL = 5 * time.Minute
 	}
+	logger.InfoContext(ctx, "Starting stress test on Redis", "keys", cfg.TotalKeys, "ttl", cfg.KeyTTL, "hash_tags", cfg.HashTags)
 
 	stats := &Stats{}
 	start := time.Now()
@@ -55,19 +76,20 @@ func Func1Run(client *redis_gateway.Client, cfg Func1Config) (*Stats, error) {
 	}
 	baseValue := string(valueTemplate)
 
-	ctx := context.Background()
+	latencies := make([]float64, 0, cfg.TotalKeys)
 
 	for i := 0; i < cfg.TotalKeys; i++ {
-		key := fmt.Sprintf("func1:key:%d", i)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		key := keyName(i, cfg.HashTags)
 		val := fmt.Sprintf("%s-%d", baseValue, i)
-		var err error
+		setStart := time.Now()
+		err := client.Set(ctx, key, val, cfg.KeyTTL)
+		latencies = append(latencies, time.Since(setStart).Seconds())
 		if err != nil {
 			stats.FailedKeys++
-			if stats.FailedKeys <= 5 {
-				log.Printf("[FUNC1] ERROR setting key %s: %v", key, err)
-			} else if stats.FailedKeys == 6 {
-				log.Printf("[FUNC1] ERROR: Too many errors. Suppressing further error logs.")
-			}
+			logger.ErrorContext(ctx, "Failed to set key", "key", key, "error", err)
 		} else {
 			stats.SuccessfulKeys++
 			stats.TotalBytes += int64(len(val))
@@ -87,8 +109,28 @@ func Func1Run(client *redis_gateway.Client, cfg Func1Config) (*Stats, error) {
 	stats.Keys = keys
 	stats.Values = values
 
-	log.Printf("[FUNC1] Completed. success=%d failed=%d duration=%.2fs throughput=%.2f keys/s totalBytes=%d",
-		stats.SuccessfulKeys, stats.FailedKeys, stats.DurationSeconds, stats.KeysPerSecond, stats.TotalBytes)
+	sort.Float64s(latencies)
+	stats.LatencyP50Seconds = metrics.Percentile(latencies, 50)
+	stats.LatencyP95Seconds = metrics.Percentile(latencies, 95)
+	stats.LatencyP99Seconds = metrics.Percentile(latencies, 99)
+
+	logger.InfoContext(ctx, "Stress test completed",
+		"success", stats.SuccessfulKeys,
+		"failed", stats.FailedKeys,
+		"duration_seconds", stats.DurationSeconds,
+		"keys_per_second", stats.KeysPerSecond,
+		"total_bytes", stats.TotalBytes,
+		"latency_p99_seconds", stats.LatencyP99Seconds,
+	)
 
 	return stats, nil
 }
+
+// keyName returns the i-th key, tagged when hashTags > 0 so that only the
+// tag picks the cluster slot.
+func keyName(i, hashTags int) string {
+	if hashTags <= 0 {
+		return fmt.Sprintf("func1:key:%d", i)
+	}
+	return fmt.Sprintf("{func1:%d}:key:%d", i%hashTags, i)
+}
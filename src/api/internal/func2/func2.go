@@ -3,12 +3,16 @@ package func2
 import (
 	"context"
 	"database/sql"
-	"fmt"
-	"log"
+	"log/slog"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"api/internal/logging"
+	"api/internal/metrics"
+	"api/internal/pg_gateway"
+
 	_ "github.com/lib/pq"
 )
 
@@ -16,6 +20,28 @@ type Stats struct {
 	SuccessfulConnections int     `json:"successful_connections"`
 	DurationSeconds       float64 `json:"duration_seconds"`
 	AverageLatencySeconds float64 `json:"average_latency_seconds"`
+	LatencyP50Seconds     float64 `json:"latency_p50_seconds"`
+	LatencyP95Seconds     float64 `json:"latency_p95_seconds"`
+	LatencyP99Seconds     float64 `json:"latency_p99_seconds"`
+	ConnectionsPerSecond  float64 `json:"connections_per_second"`
+}
+
+type Func2Config struct {
+	Host      string `json:"host"`
+	Port      string `json:"port"`
+	User      string `json:"user"`
+	Password  string `json:"-"`
+	DBName    string `json:"db_name"`
+	ConnCount int    `json:"conn_count"`
+
+	// TLS settings, as in pg_gateway.Config; the storm connects the way
+	// the worker does.
+	SSLMode     string `json:"sslmode"`
+	SSLRootCert string `json:"-"`
+	SSLCert     string `json:"-"`
+	SSLKey      string `json:"-"`
+
+	Logger *slog.Logger `json:"-"`
 }
 
 var activeConnections int32
@@ -24,7 +50,8 @@ func GetActiveConnectionsCount() int32 {
 	return atomic.LoadInt32(&activeConnections)
 }
 
-func KeepConnectionsAlive(ctx context.Context) {
+func KeepConnectionsAlive(ctx context.Context, logger *slog.Logger) {
+	logger = logging.Component(logger, "func2_keeper")
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
@@ -32,21 +59,29 @@ func KeepConnectionsAlive(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("[Func-2-KEEPER] Terminating connection keeper loop")
+			logger.Info("Terminating connection keeper loop")
 			return
 		case <-ticker.C:
 			iter++
-			log.Printf("[Func-2-KEEPER] Iteration #%d - Active database connections count: %d",
-				iter, GetActiveConnectionsCount())
+			logger.Info("Active database connections",
+				"iteration", iter, "active_connections", GetActiveConnectionsCount())
 		}
 	}
 }
-func Func2Run(host, port, user, pass, dbName string, connCount int) (*Stats, error) {
-	if connCount <= 0 {
-		connCount = 50
+
+// errorSampling bounds error output when every connection in the storm
+// fails the same way: the first few per interval are logged, the rest are
+// summarised.
+var errorSampling = logging.SamplingOptions{Interval: 10 * time.Second, First: 5}
+
+func Func2Run(ctx context.Context, cfg Func2Config) (*Stats, error) {
+	if cfg.ConnCount <= 0 {
+		cfg.ConnCount = 50
 	}
+	connCount := cfg.ConnCount
+	logger := logging.Sampled(logging.Component(cfg.Logger, "func2"), errorSampling)
 
-	log.Printf("[FUNC2] Starting optimized PostgreSQL connection storm (%d concurrent attempts)...", connCount)
+	logger.InfoContext(ctx, "Starting PostgreSQL connection storm", "concurrent_attempts", connCount)
 
 	stats := &Stats{}
 	start := time.Now()
@@ -55,28 +90,36 @@ func Func2Run(host, port, user, pass, dbName string, connCount int) (*Stats, err
 	var mu sync.Mutex
 	var latencies []float64
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable connect_timeout=5",
-		host, port, user, pass, dbName)
+	if cfg.SSLMode == "" {
+		cfg.SSLMode = "disable"
+	}
+	dsn := pg_gateway.DSN(pg_gateway.Config{
+		Host:        cfg.Host,
+		Port:        cfg.Port,
+		User:        cfg.User,
+		Password:    cfg.Password,
+		DBName:      cfg.DBName,
+		SSLMode:     cfg.SSLMode,
+		SSLRootCert: cfg.SSLRootCert,
+		SSLCert:     cfg.SSLCert,
+		SSLKey:      cfg.SSLKey,
+	}) + " connect_timeout=5"
 
 	for i := 0; i < connCount; i++ {
 		wg.Add(1)
 		go func(idx int) {
 			defer wg.Done()
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 			defer cancel()
 			connStart := time.Now()
 			db, err := sql.Open("postgres", dsn)
 			if err != nil {
-				if idx < 5 { 
-					log.Printf("[FUNC2] ERROR opening connection #%d: %v", idx, err)
-				}
+				logger.ErrorContext(ctx, "Failed to open connection", "connection", idx, "error", err)
 				return
 			}
 			defer db.Close()
 			if err := db.PingContext(ctx); err != nil {
-				if idx < 5 {
-					log.Printf("[FUNC2] ERROR pinging connection #%d: %v", idx, err)
-				}
+				logger.ErrorContext(ctx, "Failed to ping connection", "connection", idx, "error", err)
 				return
 			}
 
@@ -105,10 +148,23 @@ func Func2Run(host, port, user, pass, dbName string, connCount int) (*Stats, err
 			sum += l
 		}
 		stats.AverageLatencySeconds = sum / float64(len(latencies))
+
+		sort.Float64s(latencies)
+		stats.LatencyP50Seconds = metrics.Percentile(latencies, 50)
+		stats.LatencyP95Seconds = metrics.Percentile(latencies, 95)
+		stats.LatencyP99Seconds = metrics.Percentile(latencies, 99)
+	}
+	if total > 0 {
+		stats.ConnectionsPerSecond = float64(stats.SuccessfulConnections) / total
 	}
 
-	log.Printf("[FUNC2] Completed connection storm. successful=%d/%d duration=%.2fs avgLatency=%.4fs",
-		stats.SuccessfulConnections, connCount, stats.DurationSeconds, stats.AverageLatencySeconds)
+	logger.InfoContext(ctx, "Connection storm completed",
+		"successful", stats.SuccessfulConnections,
+		"attempts", connCount,
+		"duration_seconds", stats.DurationSeconds,
+		"avg_latency_seconds", stats.AverageLatencySeconds,
+		"latency_p99_seconds", stats.LatencyP99Seconds,
+	)
 
 	return stats, nil
 }
@@ -0,0 +1,54 @@
+package func2
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"api/internal/logging"
+)
+
+func TestFunc2RunDoesNotLogPassword(t *testing.T) {
+	const password = `it's a s3cret`
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+
+	var buf bytes.Buffer
+	logger := logging.New(logging.Options{
+		Writer:   &buf,
+		Level:    slog.LevelDebug,
+		Redactor: logging.NewRedactor(logging.DefaultRules(), "key"),
+	})
+	stats, err := Func2Run(context.Background(), Func2Config{
+		Host:      "127.0.0.1",
+		Port:      port,
+		User:      "app",
+		Password:  password,
+		DBName:    "app",
+		ConnCount: 3,
+		Logger:    logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.SuccessfulConnections != 0 {
+		t.Fatalf("SuccessfulConnections = %d against a closed port", stats.SuccessfulConnections)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "Failed to ping connection") {
+		t.Fatalf("no connection failure logged:\n%s", out)
+	}
+	for _, s := range []string{password, "s3cret", `\'s`} {
+		if strings.Contains(out, s) {
+			t.Errorf("log output leaks %q:\n%s", s, out)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Package health serves liveness and readiness endpoints. Readiness runs
+// registered dependency checks concurrently, each under its own timeout,
+// and caches the outcome so frequent probes do not hammer dependencies.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"api/internal/logging"
+	"api/internal/metrics"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+	// StatusDegraded means only optional checks failed; the service is
+	// still ready.
+	StatusDegraded = "degraded"
+)
+
+type Config struct {
+	// CacheTTL is how long a readiness result is reused. Defaults to 2s.
+	CacheTTL time.Duration
+	// DefaultTimeout applies to checks registered without one. Defaults to 2s.
+	DefaultTimeout time.Duration
+
+	Logger *slog.Logger
+}
+
+// CheckFunc returns nil when the dependency is usable.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name     string
+	timeout  time.Duration
+	fn       CheckFunc
+	optional bool
+}
+
+type CheckResult struct {
+	Status     string    `json:"status"`
+	Optional   bool      `json:"optional,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs float64   `json:"duration_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type Checker struct {
+	cfg     Config
+	metrics *metrics.Registry
+	log     *slog.Logger
+
+	mu     sync.Mutex
+	checks []check
+
+	// refresh serialises check runs so concurrent probes share one result.
+	refresh  sync.Mutex
+	cached   Report
+	cachedAt time.Time
+}
+
+func NewChecker(cfg Config) *Checker {
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = 2 * time.Second
+	}
+	if cfg.DefaultTimeout <= 0 {
+		cfg.DefaultTimeout = 2 * time.Second
+	}
+	return &Checker{cfg: cfg, log: logging.Component(cfg.Logger, "health")}
+}
+
+func (c *Checker) SetMetricsRegistry(reg *metrics.Registry) {
+	c.metrics = reg
+}
+
+// Register adds a readiness check. A zero timeout uses DefaultTimeout.
+func (c *Checker) Register(name string, timeout time.Duration, fn CheckFunc) {
+	c.add(check{name: name, timeout: timeout, fn: fn})
+}
+
+// RegisterOptional adds a check for a dependency the service can run
+// without. Its failure marks the report degraded but keeps it ready.
+func (c *Checker) RegisterOptional(name string, timeout time.Duration, fn CheckFunc) {
+	c.add(check{name: name, timeout: timeout, fn: fn, optional: true})
+}
+
+func (c *Checker) add(ch check) {
+	if ch.timeout <= 0 {
+		ch.timeout = c.cfg.DefaultTimeout
+	}
+	c.mu.Lock()
+	c.checks = append(c.checks, ch)
+	c.mu.Unlock()
+}
+
+// Ready returns the cached report, running the checks if it is stale.
+func (c *Checker) Ready(ctx context.Context) Report {
+	c.refresh.Lock()
+	defer c.refresh.Unlock()
+	if !c.cachedAt.IsZero() && time.Since(c.cachedAt) < c.cfg.CacheTTL {
+		return c.cached
+	}
+	// The report is shared with later probes, so a prober that gives up
+	// must not turn it into a failure; each check's timeout bounds the run.
+	c.cached = c.run(context.WithoutCancel(ctx))
+	c.cachedAt = time.Now()
+	return c.cached
+}
+
+func (c *Checker) run(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := append([]check(nil), c.checks...)
+	c.mu.Unlock()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, ch := range checks {
+		wg.Add(1)
+		go func(i int, ch check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, ch)
+		}(i, ch)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks))}
+	for i, ch := range checks {
+		r := results[i]
+		r.Optional = ch.optional
+		report.Checks[ch.name] = r
+		v := 1.0
+		if r.Status != StatusOK {
+			v = 0
+			switch {
+			case !ch.optional:
+				report.Status = StatusFail
+			case report.Status == StatusOK:
+				report.Status = StatusDegraded
+			}
+			c.log.WarnContext(ctx, "Readiness check failed", "check", ch.name, "optional", ch.optional, "error", r.Error)
+		}
+		if c.metrics != nil {
+			c.metrics.SetGauge("health_check_up", v, map[string]string{"check": ch.name})
+		}
+	}
+	if c.metrics != nil {
+		ready, degraded := 0.0, 0.0
+		if report.Status != StatusFail {
+			ready = 1
+		}
+		if report.Status == StatusDegraded {
+			degraded = 1
+		}
+		c.metrics.SetGauge("readiness_status", ready, nil)
+		c.metrics.SetGauge("readiness_degraded", degraded, nil)
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, ch check) (res CheckResult) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, ch.timeout)
+	defer cancel()
+	defer func() {
+		res.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+		res.CheckedAt = start.UTC()
+	}()
+
+	// A check that ignores its context must not hold the probe past its
+	// timeout, so it runs in its own goroutine; a panic there is reported
+	// as the check's failure rather than taking the process down.
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("panic: %v", p)
+			}
+		}()
+		done <- ch.fn(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return CheckResult{Status: StatusFail, Error: err.Error()}
+		}
+		return CheckResult{Status: StatusOK}
+	case <-ctx.Done():
+		return CheckResult{Status: StatusFail, Error: fmt.Sprintf("timed out after %v", ch.timeout)}
+	}
+}
+
+// LivenessHandler reports that the process is up and serving HTTP. It does
+// not touch dependencies, so an outage does not get the worker restarted.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": StatusOK})
+	})
+}
+
+// ReadinessHandler serves the checks' report: 200 when every required check
+// passes (status ok or degraded), 503 otherwise.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Ready(r.Context())
+		status := http.StatusOK
+		if report.Status == StatusFail {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,111 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+
+	"api/internal/pg_gateway"
+)
+
+// Thresholds are the tolerated relative changes, as fractions, before a
+// metric is flagged. A zero value falls back to the default.
+type Thresholds struct {
+	MaxThroughputDrop  float64 `json:"max_throughput_drop"`
+	MaxLatencyIncrease float64 `json:"max_latency_increase"`
+}
+
+var DefaultThresholds = Thresholds{
+	MaxThroughputDrop:  0.10,
+	MaxLatencyIncrease: 0.20,
+}
+
+type Finding struct {
+	Metric     string  `json:"metric"`
+	Baseline   float64 `json:"baseline"`
+	Candidate  float64 `json:"candidate"`
+	Change     float64 `json:"change"`
+	Regression bool    `json:"regression"`
+}
+
+type Report struct {
+	Kind        string     `json:"kind"`
+	BaselineID  int64      `json:"baseline_id"`
+	CandidateID int64      `json:"candidate_id"`
+	Thresholds  Thresholds `json:"thresholds"`
+	Findings    []Finding  `json:"findings"`
+	Regressed   bool       `json:"regressed"`
+}
+
+// Compare flags throughput drops and latency percentile increases of
+// candidate relative to baseline. Both runs must be of the same kind.
+func Compare(baseline, candidate *pg_gateway.LoadTestRun, th Thresholds) (*Report, error) {
+	if baseline.Kind != candidate.Kind {
+		return nil, fmt.Errorf("cannot compare %s run %d with %s run %d",
+			baseline.Kind, baseline.ID, candidate.Kind, candidate.ID)
+	}
+	if th.MaxThroughputDrop <= 0 {
+		th.MaxThroughputDrop = DefaultThresholds.MaxThroughputDrop
+	}
+	if th.MaxLatencyIncrease <= 0 {
+		th.MaxLatencyIncrease = DefaultThresholds.MaxLatencyIncrease
+	}
+
+	b, err := Summarize(baseline)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Summarize(candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &Report{
+		Kind:        baseline.Kind,
+		BaselineID:  baseline.ID,
+		CandidateID: candidate.ID,
+		Thresholds:  th,
+	}
+	add := func(metric string, base, cand float64, regressed func(change float64) bool) {
+		f := Finding{Metric: metric, Baseline: base, Candidate: cand}
+		if base != 0 {
+			f.Change = (cand - base) / base
+			f.Regression = regressed(f.Change)
+		}
+		if f.Regression {
+			rep.Regressed = true
+		}
+		rep.Findings = append(rep.Findings, f)
+	}
+
+	add("throughput", b.Throughput, c.Throughput, func(ch float64) bool { return -ch > th.MaxThroughputDrop })
+	latency := func(ch float64) bool { return ch > th.MaxLatencyIncrease }
+	add("latency_p50_seconds", b.LatencyP50, c.LatencyP50, latency)
+	add("latency_p95_seconds", b.LatencyP95, c.LatencyP95, latency)
+	add("latency_p99_seconds", b.LatencyP99, c.LatencyP99, latency)
+
+	return rep, nil
+}
+
+// WriteText renders the report as a human readable table, suitable for
+// pipeline logs.
+func (r *Report) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%s: baseline run %d vs candidate run %d\n", r.Kind, r.BaselineID, r.CandidateID); err != nil {
+		return err
+	}
+	for _, f := range r.Findings {
+		mark := "ok"
+		if f.Regression {
+			mark = "REGRESSION"
+		}
+		if _, err := fmt.Fprintf(w, "  %-22s %14.4f -> %14.4f  %+7.2f%%  %s\n",
+			f.Metric, f.Baseline, f.Candidate, f.Change*100, mark); err != nil {
+			return err
+		}
+	}
+	verdict := "no regressions"
+	if r.Regressed {
+		verdict = "regressions detected"
+	}
+	_, err := fmt.Fprintf(w, "result: %s\n", verdict)
+	return err
+}
@@ -0,0 +1,214 @@
+package loadtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/apperr"
+	"api/internal/func1"
+	"api/internal/pg_gateway"
+)
+
+// Handler serves the load test API under /loadtests/:
+//
+//	POST /loadtests/func1            run func1 and record it
+//	POST /loadtests/func2            run func2 and record it
+//	GET  /loadtests/runs             list runs (kind, label, since, limit)
+//	GET  /loadtests/runs/{id}        fetch a single run
+//	GET  /loadtests/compare          compare baseline and candidate runs
+type Handler struct {
+	runner *Runner
+	pg     *pg_gateway.Client
+}
+
+func NewHandler(runner *Runner, pg *pg_gateway.Client) *Handler {
+	return &Handler{runner: runner, pg: pg}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/loadtests"), "/")
+	switch {
+	case path == "/func1":
+		h.runFunc1(w, r)
+	case path == "/func2":
+		h.runFunc2(w, r)
+	case path == "/runs":
+		h.listRuns(w, r)
+	case strings.HasPrefix(path, "/runs/"):
+		h.getRun(w, r, strings.TrimPrefix(path, "/runs/"))
+	case path == "/compare":
+		h.compare(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type func1Request struct {
+	Label  string            `json:"label"`
+	Config func1.Func1Config `json:"config"`
+}
+
+type func2Request struct {
+	Label     string `json:"label"`
+	ConnCount int    `json:"conn_count"`
+}
+
+func (h *Handler) runFunc1(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var req func1Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	run, err := h.runner.RunFunc1(r.Context(), req.Label, req.Config)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, run)
+}
+
+func (h *Handler) runFunc2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var req func2Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	run, err := h.runner.RunFunc2(r.Context(), req.Label, req.ConnCount)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, run)
+}
+
+func (h *Handler) listRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	q := r.URL.Query()
+	f := pg_gateway.LoadTestRunFilter{
+		Kind:  q.Get("kind"),
+		Label: q.Get("label"),
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		f.Limit = n
+	}
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since, want RFC3339")
+			return
+		}
+		f.Since = t
+	}
+	runs, err := h.pg.ListLoadTestRuns(r.Context(), f)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (h *Handler) getRun(w http.ResponseWriter, r *http.Request, rawID string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid run id")
+		return
+	}
+	run, err := h.pg.GetLoadTestRun(r.Context(), id)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+func (h *Handler) compare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	q := r.URL.Query()
+	baseID, err1 := strconv.ParseInt(q.Get("baseline"), 10, 64)
+	candID, err2 := strconv.ParseInt(q.Get("candidate"), 10, 64)
+	if err1 != nil || err2 != nil {
+		writeError(w, http.StatusBadRequest, "baseline and candidate run ids are required")
+		return
+	}
+	th := DefaultThresholds
+	if v := q.Get("max_throughput_drop"); v != "" {
+		if th.MaxThroughputDrop, err1 = strconv.ParseFloat(v, 64); err1 != nil {
+			writeError(w, http.StatusBadRequest, "invalid max_throughput_drop")
+			return
+		}
+	}
+	if v := q.Get("max_latency_increase"); v != "" {
+		if th.MaxLatencyIncrease, err1 = strconv.ParseFloat(v, 64); err1 != nil {
+			writeError(w, http.StatusBadRequest, "invalid max_latency_increase")
+			return
+		}
+	}
+
+	baseline, err := h.pg.GetLoadTestRun(r.Context(), baseID)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	candidate, err := h.pg.GetLoadTestRun(r.Context(), candID)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	rep, err := Compare(baseline, candidate, th)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// writeAppError maps err's apperr kind to the status and reports the
+// kind's code alongside the message.
+func writeAppError(w http.ResponseWriter, err error) {
+	status := apperr.HTTPStatus(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "30")
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error(), "code": apperr.Code(err)})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
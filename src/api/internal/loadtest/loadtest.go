@@ -0,0 +1,308 @@
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"api/internal/apperr"
+	"api/internal/func1"
+	"api/internal/func2"
+	"api/internal/logging"
+	"api/internal/pg_gateway"
+	"api/internal/redis_gateway"
+)
+
+const (
+	KindFunc1 = "func1"
+	KindFunc2 = "func2"
+)
+
+// ErrRejected wraps the admission error when a run is refused. It is
+// apperr.Unavailable: the same run may be admitted later.
+var ErrRejected = apperr.New(apperr.Unavailable, "load test rejected")
+
+// ErrLockLost is returned for a run cancelled because its lock was lost:
+// another replica may have started the same kind of run, so the stats
+// would not be worth recording.
+var ErrLockLost = apperr.New(apperr.Conflict, "load test lock lost")
+
+// Limits caps what a run request may ask for, so that a single request
+// cannot exhaust this process's memory or the database's connections.
+// Zero fields are unlimited.
+type Limits struct {
+	MaxTotalKeys int
+	MaxValueSize int
+	// MaxTotalBytes bounds TotalKeys*ValueSize, the data func1 writes.
+	MaxTotalBytes int64
+	MaxConnCount  int
+}
+
+// DefaultLimits are used until SetLimits is called.
+var DefaultLimits = Limits{
+	MaxTotalKeys:  100000,
+	MaxValueSize:  1 << 20,
+	MaxTotalBytes: 256 << 20,
+	MaxConnCount:  200,
+}
+
+// Runner executes func1/func2 and persists each run's config and Stats.
+type Runner struct {
+	pg     *pg_gateway.Client
+	redis  *redis_gateway.Client
+	pgMu   sync.Mutex
+	pgCfg  func2.Func2Config
+	f1Def  func1.Func1Config
+	limits Limits
+	admit  func() error
+	log    *slog.Logger
+}
+
+// NewRunner builds a Runner. pgCfg supplies the connection settings func2
+// storms against; its ConnCount is used when a request does not set one.
+func NewRunner(pg *pg_gateway.Client, r *redis_gateway.Client, pgCfg func2.Func2Config, logger *slog.Logger) *Runner {
+	return &Runner{pg: pg, redis: r, pgCfg: pgCfg, limits: DefaultLimits, log: logging.Component(logger, "loadtest")}
+}
+
+// SetLimits replaces the caps requests are checked against.
+func (r *Runner) SetLimits(l Limits) {
+	r.limits = l
+}
+
+// SetAdmission installs a check consulted before every run; a non-nil
+// error rejects the run.
+func (r *Runner) SetAdmission(admit func() error) {
+	r.admit = admit
+}
+
+// SetPostgresPassword updates the password func2 connects with after a
+// credential rotation.
+func (r *Runner) SetPostgresPassword(password string) {
+	r.pgMu.Lock()
+	r.pgCfg.Password = password
+	r.pgMu.Unlock()
+}
+
+// SetFunc1Defaults supplies the func1 settings used when a request leaves
+// them zero.
+func (r *Runner) SetFunc1Defaults(cfg func1.Func1Config) {
+	r.f1Def = cfg
+}
+
+func (r *Runner) admitted() error {
+	if r.admit == nil {
+		return nil
+	}
+	if err := r.admit(); err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return nil
+}
+
+// exclusive takes the cluster-wide lock for kind, so that replicas sharing
+// a database do not measure each other's load. It fails with an
+// apperr.Conflict error while another run of kind is in progress.
+//
+// The returned ctx is cancelled with ErrLockLost if the lock is lost
+// during the run; release cancels it and unlocks.
+func (r *Runner) exclusive(ctx context.Context, kind string) (runCtx context.Context, release func(), err error) {
+	lock, err := r.redis.TryAcquireLock(ctx, "loadtest:"+kind, redis_gateway.LockOptions{AutoExtend: true})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s run: %w", kind, err)
+	}
+
+	runCtx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-lock.Lost():
+			r.log.Warn("Load test lock lost, cancelling run", "lock", lock.Name())
+			cancel(ErrLockLost)
+		case <-runCtx.Done():
+		}
+	}()
+	return runCtx, func() {
+		cancel(nil)
+		if err := lock.Release(context.Background()); err != nil {
+			r.log.Warn("Failed to release load test lock", "lock", lock.Name(), "error", err)
+		}
+	}, nil
+}
+
+// lockLost returns the error for a run whose lock was lost, or nil.
+func lockLost(runCtx context.Context, kind string) error {
+	if cause := context.Cause(runCtx); errors.Is(cause, ErrLockLost) {
+		return fmt.Errorf("%s run: %w", kind, cause)
+	}
+	return nil
+}
+
+func (r *Runner) RunFunc1(ctx context.Context, label string, cfg func1.Func1Config) (*pg_gateway.LoadTestRun, error) {
+	if err := r.admitted(); err != nil {
+		return nil, err
+	}
+	// A run against a degraded client would only record ErrUnavailable.
+	if !r.redis.Available() {
+		return nil, fmt.Errorf("%w: %w", ErrRejected, redis_gateway.ErrUnavailable)
+	}
+	runCtx, release, err := r.exclusive(ctx, KindFunc1)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if cfg.TotalKeys <= 0 {
+		cfg.TotalKeys = r.f1Def.TotalKeys
+	}
+	if cfg.ValueSize <= 0 {
+		cfg.ValueSize = r.f1Def.ValueSize
+	}
+	if cfg.KeyTTL <= 0 {
+		cfg.KeyTTL = r.f1Def.KeyTTL
+	}
+	// Negative HashTags asks for untagged keys even when the default is set.
+	if cfg.HashTags == 0 {
+		cfg.HashTags = r.f1Def.HashTags
+	}
+	if err := r.limits.checkFunc1(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = r.log
+	}
+	started := time.Now()
+	stats, err := func1.Func1Run(runCtx, r.redis, cfg)
+	if err := lockLost(runCtx, KindFunc1); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("func1 run: %w", err)
+	}
+	// Keys and values are only useful in-process; keep the stored row small.
+	stored := *stats
+	stored.Keys, stored.Values = nil, nil
+	return r.record(ctx, KindFunc1, label, cfg, stored, started)
+}
+
+func (r *Runner) RunFunc2(ctx context.Context, label string, connCount int) (*pg_gateway.LoadTestRun, error) {
+	if err := r.admitted(); err != nil {
+		return nil, err
+	}
+	runCtx, release, err := r.exclusive(ctx, KindFunc2)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	r.pgMu.Lock()
+	cfg := r.pgCfg
+	r.pgMu.Unlock()
+	if connCount > 0 {
+		cfg.ConnCount = connCount
+	}
+	if err := r.limits.checkFunc2(cfg.ConnCount); err != nil {
+		return nil, err
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = r.log
+	}
+	started := time.Now()
+	stats, err := func2.Func2Run(runCtx, cfg)
+	if err := lockLost(runCtx, KindFunc2); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("func2 run: %w", err)
+	}
+	return r.record(ctx, KindFunc2, label, cfg, *stats, started)
+}
+
+// checkFunc1 rejects a func1 config, with defaults applied, that exceeds l.
+func (l Limits) checkFunc1(cfg func1.Func1Config) error {
+	var problems []string
+	if l.MaxTotalKeys > 0 && cfg.TotalKeys > l.MaxTotalKeys {
+		problems = append(problems, fmt.Sprintf("total_keys must not exceed %d", l.MaxTotalKeys))
+	}
+	if l.MaxValueSize > 0 && cfg.ValueSize > l.MaxValueSize {
+		problems = append(problems, fmt.Sprintf("value_size must not exceed %d", l.MaxValueSize))
+	}
+	if l.MaxTotalBytes > 0 && int64(cfg.TotalKeys)*int64(cfg.ValueSize) > l.MaxTotalBytes {
+		problems = append(problems, fmt.Sprintf("total_keys*value_size must not exceed %d bytes", l.MaxTotalBytes))
+	}
+	if cfg.KeyTTL < 0 {
+		problems = append(problems, "key_ttl must not be negative")
+	}
+	return invalid("loadtest.RunFunc1", problems)
+}
+
+func (l Limits) checkFunc2(connCount int) error {
+	if l.MaxConnCount > 0 && connCount > l.MaxConnCount {
+		return invalid("loadtest.RunFunc2", []string{fmt.Sprintf("conn_count must not exceed %d", l.MaxConnCount)})
+	}
+	return nil
+}
+
+func invalid(op string, problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return &apperr.Error{Kind: apperr.Validation, Op: op, Msg: strings.Join(problems, "; ")}
+}
+
+func (r *Runner) record(ctx context.Context, kind, label string, cfg, stats interface{}, started time.Time) (*pg_gateway.LoadTestRun, error) {
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s config: %w", kind, err)
+	}
+	statsJSON, err := json.Marshal(stats)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s stats: %w", kind, err)
+	}
+	run := pg_gateway.LoadTestRun{
+		Kind:       kind,
+		Label:      label,
+		Config:     string(cfgJSON),
+		Stats:      string(statsJSON),
+		StartedAt:  started.UTC(),
+		FinishedAt: time.Now().UTC(),
+	}
+	id, err := r.pg.SaveLoadTestRun(ctx, run)
+	if err != nil {
+		return nil, fmt.Errorf("save %s run: %w", kind, err)
+	}
+	run.ID = id
+	r.log.InfoContext(ctx, "Recorded load test run", "kind", kind, "run_id", id, "label", label)
+	return &run, nil
+}
+
+// Summary is the kind-independent view of a run used for comparisons.
+// Throughput is keys/s for func1 and connections/s for func2.
+type Summary struct {
+	Throughput float64 `json:"throughput"`
+	LatencyP50 float64 `json:"latency_p50_seconds"`
+	LatencyP95 float64 `json:"latency_p95_seconds"`
+	LatencyP99 float64 `json:"latency_p99_seconds"`
+}
+
+func Summarize(run *pg_gateway.LoadTestRun) (Summary, error) {
+	var s Summary
+	switch run.Kind {
+	case KindFunc1:
+		var st func1.Stats
+		if err := json.Unmarshal([]byte(run.Stats), &st); err != nil {
+			return s, fmt.Errorf("decode func1 stats for run %d: %w", run.ID, err)
+		}
+		s = Summary{st.KeysPerSecond, st.LatencyP50Seconds, st.LatencyP95Seconds, st.LatencyP99Seconds}
+	case KindFunc2:
+		var st func2.Stats
+		if err := json.Unmarshal([]byte(run.Stats), &st); err != nil {
+			return s, fmt.Errorf("decode func2 stats for run %d: %w", run.ID, err)
+		}
+		s = Summary{st.ConnectionsPerSecond, st.LatencyP50Seconds, st.LatencyP95Seconds, st.LatencyP99Seconds}
+	default:
+		return s, fmt.Errorf("run %d: unknown kind %q", run.ID, run.Kind)
+	}
+	return s, nil
+}
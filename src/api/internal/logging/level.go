@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// LevelHandler reports the current level on GET and changes it on PUT or
+// POST, taking the new level from ?level= or a {"level": "..."} body.
+func LevelHandler(v *slog.LevelVar, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			raw := r.URL.Query().Get("level")
+			if raw == "" {
+				var body struct {
+					Level string `json:"level"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					http.Error(w, "level is required", http.StatusBadRequest)
+					return
+				}
+				raw = body.Level
+			}
+			lvl, err := ParseLevel(raw)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			old := v.Level()
+			v.Set(lvl)
+			if logger != nil {
+				logger.Info("Log level changed", "from", LevelName(old), "to", LevelName(lvl))
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"level": LevelName(v.Level())})
+	})
+}
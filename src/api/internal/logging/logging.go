@@ -0,0 +1,346 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api/internal/correlation"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LevelFatal is logged by Fatal right before the process exits.
+const LevelFatal = slog.Level(12)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Entry is the JSON shape of a log line. The field names are the ones the
+// worker has always emitted and log pipelines depend on them.
+type Entry struct {
+	Timestamp string                 `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Message   string                 `json:"message"`
+	Component string                 `json:"component"`
+	Duration  float64                `json:"duration_ms,omitempty"`
+	Context   map[string]interface{} `json:"context,omitempty"`
+}
+
+type Options struct {
+	// Format is FormatJSON (default) or FormatText.
+	Format string
+	// Level is consulted on every record, so passing a *slog.LevelVar makes
+	// the level adjustable at runtime. Defaults to Info.
+	Level  slog.Leveler
+	Writer io.Writer
+	// Redactor, when set, is applied to every record's context and message.
+	Redactor *Redactor
+}
+
+// New returns a logger writing Entry-shaped records.
+func New(opts Options) *slog.Logger {
+	return slog.New(NewHandler(opts))
+}
+
+// Component returns l tagged with a component name, falling back to
+// slog.Default() when l is nil so gateways work without explicit wiring.
+func Component(l *slog.Logger, name string) *slog.Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return l.With("component", name)
+}
+
+// Fatal logs at LevelFatal and exits with status 1.
+func Fatal(l *slog.Logger, msg string, args ...any) {
+	l.Log(context.Background(), LevelFatal, msg, args...)
+	os.Exit(1)
+}
+
+// ParseLevel accepts debug, info, warn(ing), error and fatal.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	case "fatal":
+		return LevelFatal, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+func LevelName(l slog.Level) string {
+	switch {
+	case l >= LevelFatal:
+		return "FATAL"
+	case l >= slog.LevelError:
+		return "ERROR"
+	case l >= slog.LevelWarn:
+		return "WARN"
+	case l >= slog.LevelInfo:
+		return "INFO"
+	}
+	return "DEBUG"
+}
+
+type output struct {
+	mu       sync.Mutex
+	w        io.Writer
+	format   string
+	redactor *Redactor
+}
+
+// Handler is a slog.Handler producing Entry records. The "component" and
+// "duration_ms" attributes are lifted to the top level; everything else
+// goes under "context", along with the request_id and trace/span IDs
+// carried by the record's context, if any.
+type Handler struct {
+	out       *output
+	level     slog.Leveler
+	component string
+	attrs     []slog.Attr
+	groups    []string
+}
+
+func NewHandler(opts Options) *Handler {
+	if opts.Writer == nil {
+		opts.Writer = os.Stdout
+	}
+	if opts.Level == nil {
+		opts.Level = slog.LevelInfo
+	}
+	if opts.Format == "" {
+		opts.Format = FormatJSON
+	}
+	return &Handler{
+		out:   &output{w: opts.Writer, format: opts.Format, redactor: opts.Redactor},
+		level: opts.Level,
+	}
+}
+
+func (h *Handler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := h.clone()
+	for _, a := range attrs {
+		if len(c.groups) == 0 && a.Key == "component" {
+			c.component = a.Value.Resolve().String()
+			continue
+		}
+		c.attrs = append(c.attrs, wrapGroups(c.groups, a))
+	}
+	return c
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := h.clone()
+	c.groups = append(c.groups, name)
+	return c
+}
+
+func (h *Handler) clone() *Handler {
+	c := *h
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	c.groups = append([]string(nil), h.groups...)
+	return &c
+}
+
+func wrapGroups(groups []string, a slog.Attr) slog.Attr {
+	for i := len(groups) - 1; i >= 0; i-- {
+		a = slog.Attr{Key: groups[i], Value: slog.GroupValue(a)}
+	}
+	return a
+}
+
+func (h *Handler) Handle(rctx context.Context, r slog.Record) error {
+	e := Entry{
+		Timestamp: r.Time.Format(time.RFC3339Nano),
+		Level:     LevelName(r.Level),
+		Message:   r.Message,
+		Component: h.component,
+	}
+	if r.Time.IsZero() {
+		e.Timestamp = time.Now().Format(time.RFC3339Nano)
+	}
+
+	ctx := make(map[string]interface{})
+	for _, a := range h.attrs {
+		addAttr(ctx, a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if len(h.groups) == 0 {
+			switch a.Key {
+			case "component":
+				e.Component = a.Value.Resolve().String()
+				return true
+			case "duration_ms":
+				if f, ok := toFloat(a.Value.Resolve()); ok {
+					e.Duration = f
+					return true
+				}
+			}
+		}
+		addAttr(ctx, wrapGroups(h.groups, a))
+		return true
+	})
+	if id := correlation.ID(rctx); id != "" {
+		if _, set := ctx["request_id"]; !set {
+			ctx["request_id"] = id
+		}
+	}
+	if sc := trace.SpanContextFromContext(rctx); sc.IsValid() {
+		ctx["trace_id"] = sc.TraceID().String()
+		ctx["span_id"] = sc.SpanID().String()
+	}
+	if rd := h.out.redactor; rd != nil {
+		rd.Map(ctx)
+		e.Message = rd.String(e.Message)
+	}
+	if len(ctx) > 0 {
+		e.Context = ctx
+	}
+
+	var line []byte
+	if h.out.format == FormatText {
+		line = formatText(e)
+	} else {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		line = append(b, '\n')
+	}
+
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	_, err := h.out.w.Write(line)
+	return err
+}
+
+func addAttr(m map[string]interface{}, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		attrs := v.Group()
+		if len(attrs) == 0 {
+			return
+		}
+		// Inline groups (empty key) merge into the parent.
+		target := m
+		if a.Key != "" {
+			sub, ok := m[a.Key].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				m[a.Key] = sub
+			}
+			target = sub
+		}
+		for _, ga := range attrs {
+			addAttr(target, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	m[a.Key] = value(v)
+}
+
+func value(v slog.Value) interface{} {
+	switch v.Kind() {
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return x.Error()
+		case fmt.Stringer:
+			return x.String()
+		}
+	}
+	return v.Any()
+}
+
+func toFloat(v slog.Value) (float64, bool) {
+	switch v.Kind() {
+	case slog.KindFloat64:
+		return v.Float64(), true
+	case slog.KindInt64:
+		return float64(v.Int64()), true
+	case slog.KindUint64:
+		return float64(v.Uint64()), true
+	case slog.KindDuration:
+		return float64(v.Duration()) / float64(time.Millisecond), true
+	}
+	return 0, false
+}
+
+// formatText renders "timestamp LEVEL [component] message k=v ..." with
+// context keys flattened and sorted.
+func formatText(e Entry) []byte {
+	var sb strings.Builder
+	sb.WriteString(e.Timestamp)
+	sb.WriteByte(' ')
+	sb.WriteString(e.Level)
+	if e.Component != "" {
+		sb.WriteString(" [")
+		sb.WriteString(e.Component)
+		sb.WriteByte(']')
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(e.Message)
+	if e.Duration != 0 {
+		fmt.Fprintf(&sb, " duration_ms=%g", e.Duration)
+	}
+	flat := make(map[string]interface{})
+	flatten("", e.Context, flat)
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := fmt.Sprint(flat[k])
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(&sb, " %s=%s", k, s)
+	}
+	sb.WriteByte('\n')
+	return []byte(sb.String())
+}
+
+func flatten(prefix string, m map[string]interface{}, out map[string]interface{}) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			flatten(key, sub, out)
+			continue
+		}
+		out[key] = v
+	}
+}
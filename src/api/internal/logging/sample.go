@@ -0,0 +1,153 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type SamplingOptions struct {
+	// Interval is the window over which First and Thereafter apply.
+	// Defaults to one second.
+	Interval time.Duration
+	// First records of each message per interval are always logged.
+	First int
+	// Thereafter, every Mth further record is logged. Zero drops them all
+	// until the interval ends.
+	Thereafter int
+	// MinLevel is the lowest level that is sampled; quieter records pass
+	// through untouched. Defaults to Warn.
+	MinLevel slog.Leveler
+}
+
+// SamplingHandler rate-limits identical messages (same component, level
+// and message text) so an outage does not flood the log. Whenever records
+// are dropped in an interval, a single "Suppressed similar log messages"
+// record with the count is emitted once the interval ends.
+type SamplingHandler struct {
+	next      slog.Handler
+	state     *samplerState
+	component string
+}
+
+type samplerState struct {
+	opts    SamplingOptions
+	mu      sync.Mutex
+	buckets map[string]*sampleBucket
+}
+
+type sampleBucket struct {
+	windowStart time.Time
+	seen        int
+	suppressed  int
+	pending     bool
+
+	// handler, level and msg describe the last suppressed record, used to
+	// emit the summary through the same component logger.
+	handler slog.Handler
+	level   slog.Level
+	msg     string
+}
+
+func NewSamplingHandler(next slog.Handler, opts SamplingOptions) *SamplingHandler {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.MinLevel == nil {
+		opts.MinLevel = slog.LevelWarn
+	}
+	return &SamplingHandler{
+		next:  next,
+		state: &samplerState{opts: opts, buckets: make(map[string]*sampleBucket)},
+	}
+}
+
+// Sampled returns l with its output sampled according to opts.
+func Sampled(l *slog.Logger, opts SamplingOptions) *slog.Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return slog.New(NewSamplingHandler(l.Handler(), opts))
+}
+
+func (h *SamplingHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+func (h *SamplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := &SamplingHandler{next: h.next.WithAttrs(attrs), state: h.state, component: h.component}
+	for _, a := range attrs {
+		if a.Key == "component" {
+			c.component = a.Value.Resolve().String()
+		}
+	}
+	return c
+}
+
+func (h *SamplingHandler) WithGroup(name string) slog.Handler {
+	return &SamplingHandler{next: h.next.WithGroup(name), state: h.state, component: h.component}
+}
+
+func (h *SamplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	s := h.state
+	if r.Level < s.opts.MinLevel.Level() {
+		return h.next.Handle(ctx, r)
+	}
+	key := h.component + "\x00" + r.Level.String() + "\x00" + r.Message
+	now := time.Now()
+
+	s.mu.Lock()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &sampleBucket{windowStart: now}
+		s.buckets[key] = b
+	} else if now.Sub(b.windowStart) >= s.opts.Interval {
+		b.windowStart, b.seen = now, 0
+	}
+	b.seen++
+	allow := b.seen <= s.opts.First ||
+		(s.opts.Thereafter > 0 && (b.seen-s.opts.First)%s.opts.Thereafter == 0)
+	if !allow {
+		b.suppressed++
+		b.handler, b.level, b.msg = h.next, r.Level, r.Message
+		if !b.pending {
+			b.pending = true
+			time.AfterFunc(b.windowStart.Add(s.opts.Interval).Sub(now), func() { s.flush(key) })
+		}
+	}
+	s.mu.Unlock()
+
+	if !allow {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// flush emits the summary for key's finished interval and forgets buckets
+// that have gone quiet.
+func (s *samplerState) flush(key string) {
+	s.mu.Lock()
+	b, ok := s.buckets[key]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	n, handler, level, msg := b.suppressed, b.handler, b.level, b.msg
+	b.suppressed, b.pending, b.handler = 0, false, nil
+	if time.Since(b.windowStart) >= s.opts.Interval {
+		delete(s.buckets, key)
+	}
+	s.mu.Unlock()
+
+	if n == 0 || handler == nil {
+		return
+	}
+	r := slog.NewRecord(time.Now(), level, "Suppressed similar log messages", 0)
+	r.AddAttrs(
+		slog.Int("suppressed", n),
+		slog.String("sampled_message", msg),
+		slog.Duration("interval", s.opts.Interval),
+	)
+	_ = handler.Handle(context.Background(), r)
+}
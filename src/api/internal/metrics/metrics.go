@@ -2,7 +2,10 @@ package metrics
 
 import (
         "fmt"
+        "math"
+        "net/http"
         "sort"
+        "strconv"
         "strings"
         "sync"
 )
@@ -10,8 +13,9 @@ import (
 type metricType string
 
 const (
-        typeCounter metricType = "counter"
-        typeGauge   metricType = "gauge"
+        typeCounter   metricType = "counter"
+        typeGauge     metricType = "gauge"
+        typeHistogram metricType = "histogram"
 )
 
 type sample struct {
@@ -20,11 +24,23 @@ type sample struct {
 }
 
 type metric struct {
-        name string
-        mtyp metricType
-        help string
-        mu   sync.RWMutex
-        data map[string]float64
+        name  string
+        mtyp  metricType
+        help  string
+        mu    sync.RWMutex
+        data  map[string]float64
+        hists map[string]*histogram
+}
+
+// histogram is a cumulative bucketed distribution. counts[i] is the number
+// of observations <= bounds[i]; count includes observations above the last
+// bound (the +Inf bucket).
+type histogram struct {
+        labels map[string]string
+        bounds []float64
+        counts []uint64
+        count  uint64
+        sum    float64
 }
 
 type Registry struct {
@@ -47,9 +63,10 @@ func (r *Registry) getOrCreate(name string, mtyp metricType) *metric {
         }
 
         m := &metric{
-                name: name,
-                mtyp: mtyp,
-                data: make(map[string]float64),
+                name:  name,
+                mtyp:  mtyp,
+                data:  make(map[string]float64),
+                hists: make(map[string]*histogram),
         }
         r.metrics[name] = m
         return m
@@ -80,6 +97,55 @@ func (r *Registry) IncrementCounter(name string, labels map[string]string) {
         m.data[key] += 1
 }
 
+// AddCounter increases a counter by delta. Negative deltas are ignored so the
+// series stays monotonic.
+func (r *Registry) AddCounter(name string, delta float64, labels map[string]string) {
+        if delta < 0 {
+                return
+        }
+        m := r.getOrCreate(name, typeCounter)
+        key := labelsKey(labels)
+
+        m.mu.Lock()
+        defer m.mu.Unlock()
+        m.data[key] += delta
+}
+
+// SetCounter mirrors a total that is already maintained elsewhere (for
+// example a runtime statistic counted since process start). The series is
+// exported as a counter, unlike SetGauge.
+func (r *Registry) SetCounter(name string, value float64, labels map[string]string) {
+        m := r.getOrCreate(name, typeCounter)
+        key := labelsKey(labels)
+
+        m.mu.Lock()
+        defer m.mu.Unlock()
+        m.data[key] = value
+}
+
+// SetHistogram replaces a histogram series. bounds are ascending bucket
+// upper bounds and counts the cumulative number of observations <= each
+// bound; count is the total number of observations.
+func (r *Registry) SetHistogram(name string, labels map[string]string, bounds []float64, counts []uint64, count uint64, sum float64) {
+        m := r.getOrCreate(name, typeHistogram)
+        key := labelsKey(labels)
+
+        h := &histogram{
+                labels: make(map[string]string, len(labels)),
+                bounds: append([]float64(nil), bounds...),
+                counts: append([]uint64(nil), counts...),
+                count:  count,
+                sum:    sum,
+        }
+        for k, v := range labels {
+                h.labels[k] = v
+        }
+
+        m.mu.Lock()
+        defer m.mu.Unlock()
+        m.hists[key] = h
+}
+
 func (r *Registry) SetGauge(name string, value float64, labels map[string]string) {
         m := r.getOrCreate(name, typeGauge)
         key := labelsKey(labels)
@@ -91,16 +157,83 @@ func (r *Registry) SetGauge(name string, value float64, labels map[string]string
 
 func (r *Registry) Export() string {
         r.mu.RLock()
-        defer r.mu.RUnlock()
+        names := make([]string, 0, len(r.metrics))
+        for name := range r.metrics {
+                names = append(names, name)
+        }
+        r.mu.RUnlock()
+        sort.Strings(names)
 
         var sb strings.Builder
 
-        for _, m := range r.metrics {
-                for labelKey, v := range m.data {
-                        line := fmt.Sprintf("%s%s %f\n", m.name, labelKey, v)
+        for _, name := range names {
+                r.mu.RLock()
+                m := r.metrics[name]
+                r.mu.RUnlock()
+
+                m.mu.RLock()
+                fmt.Fprintf(&sb, "# TYPE %s %s\n", m.name, m.mtyp)
+                keys := make([]string, 0, len(m.data))
+                for labelKey := range m.data {
+                        keys = append(keys, labelKey)
+                }
+                sort.Strings(keys)
+                for _, labelKey := range keys {
+                        line := fmt.Sprintf("%s%s %f\n", m.name, labelKey, m.data[labelKey])
                         sb.WriteString(line)
                 }
+                keys = keys[:0]
+                for labelKey := range m.hists {
+                        keys = append(keys, labelKey)
+                }
+                sort.Strings(keys)
+                for _, labelKey := range keys {
+                        writeHistogram(&sb, m.name, m.hists[labelKey])
+                }
+                m.mu.RUnlock()
         }
 
         return sb.String()
 }
+
+func writeHistogram(sb *strings.Builder, name string, h *histogram) {
+        withLE := func(le string) string {
+                labels := make(map[string]string, len(h.labels)+1)
+                for k, v := range h.labels {
+                        labels[k] = v
+                }
+                labels["le"] = le
+                return labelsKey(labels)
+        }
+        for i, b := range h.bounds {
+                fmt.Fprintf(sb, "%s_bucket%s %d\n", name, withLE(strconv.FormatFloat(b, 'g', -1, 64)), h.counts[i])
+        }
+        fmt.Fprintf(sb, "%s_bucket%s %d\n", name, withLE("+Inf"), h.count)
+        fmt.Fprintf(sb, "%s_sum%s %f\n", name, labelsKey(h.labels), h.sum)
+        fmt.Fprintf(sb, "%s_count%s %d\n", name, labelsKey(h.labels), h.count)
+}
+
+// ServeHTTP writes the registry in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+        _, _ = w.Write([]byte(r.Export()))
+}
+
+// Percentile returns the p-th percentile (0-100) of an ascending sorted slice
+// using nearest-rank. It returns 0 for an empty slice.
+func Percentile(sorted []float64, p float64) float64 {
+        if len(sorted) == 0 {
+                return 0
+        }
+        if p <= 0 {
+                return sorted[0]
+        }
+        if p >= 100 {
+                return sorted[len(sorted)-1]
+        }
+        rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+        if rank < 0 {
+                rank = 0
+        }
+        return sorted[rank]
+}
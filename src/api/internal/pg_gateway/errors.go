@@ -0,0 +1,79 @@
+package pg_gateway
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+
+	"api/internal/apperr"
+	"api/internal/breaker"
+
+	"github.com/lib/pq"
+)
+
+// retryableCodes are SQLSTATEs for failures that a fresh attempt can get
+// past: conflicts with concurrent transactions, and a server that is
+// restarting, failing over or out of connection slots.
+var retryableCodes = map[pq.ErrorCode]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"25006": true, // read_only_sql_transaction: writing to a demoted primary
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// Retryable reports whether err is transient, so the same statement may
+// succeed if issued again. Timeouts are not: the server is slow, and
+// retrying would only add load (the breaker handles that case).
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Class() == "08" || retryableCodes[pqErr.Code]
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return !netErr.Timeout()
+	}
+	return false
+}
+
+// translate maps err to an apperr kind, keeping it as the cause. op names
+// the failed operation, e.g. "pg.SaveUser".
+func translate(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var appErr *apperr.Error
+	if errors.As(err, &appErr) {
+		return err
+	}
+	var pqErr *pq.Error
+	isPQ := errors.As(err, &pqErr)
+	kind := apperr.Internal
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		kind = apperr.NotFound
+	case errors.Is(err, breaker.ErrCircuitOpen):
+		kind = apperr.Unavailable
+	case errors.Is(err, context.DeadlineExceeded), isPQ && pqErr.Code == "57014": // query_canceled
+		kind = apperr.Timeout
+	case isPQ && (pqErr.Code == "23505" || pqErr.Code == "23P01"): // unique or exclusion violation
+		kind = apperr.Conflict
+	case isPQ && (pqErr.Code.Class() == "22" || pqErr.Code.Class() == "23"):
+		kind = apperr.Validation
+	case Retryable(err):
+		kind = apperr.Unavailable
+	}
+	return apperr.E(kind, op, err)
+}
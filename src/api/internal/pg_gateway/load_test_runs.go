@@ -0,0 +1,170 @@
+package pg_gateway
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"api/internal/apperr"
+)
+
+// ErrRunNotFound is returned when a load test run ID does not exist.
+var ErrRunNotFound = apperr.New(apperr.NotFound, "load test run not found")
+
+// LoadTestRun is a persisted func1/func2 execution. Config and Stats hold the
+// JSON encoding of the runner's config and Stats structs.
+type LoadTestRun struct {
+	ID         int64     `json:"id"`
+	Kind       string    `json:"kind"`
+	Label      string    `json:"label,omitempty"`
+	Config     string    `json:"config"`
+	Stats      string    `json:"stats"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// LoadTestRunFilter narrows ListLoadTestRuns. Zero values are ignored.
+type LoadTestRunFilter struct {
+	Kind  string
+	Label string
+	Since time.Time
+	Limit int
+}
+
+func (c *Client) CreateLoadTestRunsTable(ctx context.Context) error {
+	q := `
+CREATE TABLE IF NOT EXISTS load_test_runs (
+    id          BIGSERIAL PRIMARY KEY,
+    kind        TEXT NOT NULL,
+    label       TEXT NOT NULL DEFAULT '',
+    config      JSONB NOT NULL,
+    stats       JSONB NOT NULL,
+    started_at  TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS load_test_runs_kind_started_idx ON load_test_runs (kind, started_at DESC);
+`
+	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.ExecTimeout)
+	defer cancel()
+
+	_, err := c.conn().ExecContext(ctx, q)
+	if err != nil {
+		c.log.ErrorContext(ctx, "Failed to create load_test_runs table", "error", err)
+	}
+	return err
+}
+
+func (c *Client) SaveLoadTestRun(ctx context.Context, run LoadTestRun) (int64, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return 0, translate("pg.SaveLoadTestRun", err)
+	}
+	start := time.Now()
+	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.ExecTimeout)
+	defer cancel()
+
+	var id int64
+	err := c.conn().QueryRowContext(ctx, `
+INSERT INTO load_test_runs (kind, label, config, stats, started_at, finished_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id
+`, run.Kind, run.Label, run.Config, run.Stats, run.StartedAt, run.FinishedAt).Scan(&id)
+
+	c.observe(ctx, "pg_save_load_test_run", nil, err, time.Since(start))
+	return id, translate("pg.SaveLoadTestRun", err)
+}
+
+func (c *Client) GetLoadTestRun(ctx context.Context, id int64) (*LoadTestRun, error) {
+	db, rep := c.reader()
+	if rep == nil {
+		if err := c.breaker.Allow(); err != nil {
+			return nil, translate("pg.GetLoadTestRun", err)
+		}
+	}
+	start := time.Now()
+	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.QueryTimeout)
+	defer cancel()
+
+	var r LoadTestRun
+	err := db.QueryRowContext(ctx, `
+SELECT id, kind, label, config::text, stats::text, started_at, finished_at
+FROM load_test_runs WHERE id = $1
+`, id).Scan(&r.ID, &r.Kind, &r.Label, &r.Config, &r.Stats, &r.StartedAt, &r.FinishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.observe(ctx, "pg_get_load_test_run", rep, nil, time.Since(start))
+		return nil, ErrRunNotFound
+	}
+	c.observe(ctx, "pg_get_load_test_run", rep, err, time.Since(start))
+	if err != nil {
+		return nil, translate("pg.GetLoadTestRun", err)
+	}
+	return &r, nil
+}
+
+// ListLoadTestRuns returns runs matching f, newest first.
+func (c *Client) ListLoadTestRuns(ctx context.Context, f LoadTestRunFilter) (_ []LoadTestRun, err error) {
+	db, rep := c.reader()
+	if rep == nil {
+		if err := c.breaker.Allow(); err != nil {
+			return nil, translate("pg.ListLoadTestRuns", err)
+		}
+	}
+	defer func() { err = translate("pg.ListLoadTestRuns", err) }()
+	start := time.Now()
+	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.QueryTimeout)
+	defer cancel()
+
+	if f.Limit <= 0 || f.Limit > 1000 {
+		f.Limit = 100
+	}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+	if f.Kind != "" {
+		args = append(args, f.Kind)
+		where = append(where, fmt.Sprintf("kind = $%d", len(args)))
+	}
+	if f.Label != "" {
+		args = append(args, f.Label)
+		where = append(where, fmt.Sprintf("label = $%d", len(args)))
+	}
+	if !f.Since.IsZero() {
+		args = append(args, f.Since)
+		where = append(where, fmt.Sprintf("started_at >= $%d", len(args)))
+	}
+	q := `SELECT id, kind, label, config::text, stats::text, started_at, finished_at FROM load_test_runs`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit)
+	q += fmt.Sprintf(" ORDER BY started_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		c.observe(ctx, "pg_list_load_test_runs", rep, err, time.Since(start))
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := make([]LoadTestRun, 0, f.Limit)
+	for rows.Next() {
+		var r LoadTestRun
+		if err := rows.Scan(&r.ID, &r.Kind, &r.Label, &r.Config, &r.Stats, &r.StartedAt, &r.FinishedAt); err != nil {
+			c.observe(ctx, "pg_list_load_test_runs", rep, err, time.Since(start))
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	if err := rows.Err(); err != nil {
+		c.observe(ctx, "pg_list_load_test_runs", rep, err, time.Since(start))
+		return nil, err
+	}
+
+	c.observe(ctx, "pg_list_load_test_runs", rep, nil, time.Since(start))
+	return runs, nil
+}
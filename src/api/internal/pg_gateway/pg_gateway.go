@@ -3,13 +3,24 @@ package pg_gateway
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"api/internal/apperr"
+	"api/internal/breaker"
+	"api/internal/logging"
 	"api/internal/metrics"
+	"api/internal/retry"
+	"api/internal/tracing"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Config struct {
@@ -19,8 +30,13 @@ type Config struct {
 	Password string
 	DBName   string
 
-	SSLMode string // 
-	
+	// SSLMode is a lib/pq sslmode: disable, require, verify-ca or
+	// verify-full. verify-full checks the server certificate against Host.
+	SSLMode     string
+	SSLRootCert string // PEM CA bundle
+	SSLCert     string // PEM client certificate, for mutual TLS
+	SSLKey      string
+
 	MaxOpenConns    int
 	MaxIdleConns    int
 	ConnMaxLifetime time.Duration
@@ -28,12 +44,36 @@ type Config struct {
 	PingTimeout  time.Duration
 	QueryTimeout time.Duration
 	ExecTimeout  time.Duration
+
+	// Breaker guards queries; its Name defaults to "postgres".
+	Breaker breaker.Config
+	// Retry re-issues statements that fail with a Retryable error. Its
+	// Retryable defaults to the package's.
+	Retry retry.Policy
+
+	// Replicas are read-only standbys, as host or host:port (Port if
+	// omitted), that serve read-only queries while healthy; see Run.
+	Replicas []string
+	// MaxReplicationLag takes a replica out of rotation. Defaults to 10s.
+	MaxReplicationLag time.Duration
+	// ReplicaCheckInterval defaults to 5s.
+	ReplicaCheckInterval time.Duration
+
+	Logger *slog.Logger
 }
 
 type Client struct {
-	db      *sql.DB
+	// db is swapped by UpdatePassword; always read it through conn().
+	db      atomic.Pointer[sql.DB]
 	metrics *metrics.Registry
 	cfg     Config
+	log     *slog.Logger
+	breaker *breaker.Breaker
+
+	replicas    []*replica
+	nextReplica atomic.Uint64
+
+	rotateMu sync.Mutex
 }
 
 func NewPGClient(cfg Config) (*Client, error) {
@@ -58,16 +98,105 @@ func NewPGClient(cfg Config) (*Client, error) {
 	if cfg.ExecTimeout == 0 {
 		cfg.ExecTimeout = 5 * time.Second
 	}
+	if cfg.Breaker.Name == "" {
+		cfg.Breaker.Name = "postgres"
+	}
+	if cfg.Breaker.IsFailure == nil {
+		cfg.Breaker.IsFailure = breakerFailure
+	}
+	if cfg.Breaker.Logger == nil {
+		cfg.Breaker.Logger = cfg.Logger
+	}
+	if cfg.Retry.Retryable == nil {
+		cfg.Retry.Retryable = Retryable
+	}
+	if cfg.MaxReplicationLag == 0 {
+		cfg.MaxReplicationLag = 10 * time.Second
+	}
+	if cfg.ReplicaCheckInterval == 0 {
+		cfg.ReplicaCheckInterval = 5 * time.Second
+	}
 
-	dsn := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode,
-	)
-	log.Printf("[POSTGRES] Opening connection (host=%s port=%s dbname=%s user=%s sslmode=%s)",
-		cfg.Host, cfg.Port, cfg.DBName, cfg.User, cfg.SSLMode,
+	logger := logging.Component(cfg.Logger, "postgres")
+	logger.Info("Opening connection",
+		"host", cfg.Host, "port", cfg.Port, "dbname", cfg.DBName, "user", cfg.User, "sslmode", cfg.SSLMode,
+		"client_cert", cfg.SSLCert != "",
+		"replicas", len(cfg.Replicas),
 	)
 
-	db, err := sql.Open("postgres", dsn)
+	db, err := openPool(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Replicas start out of rotation; Run brings them in once checked.
+	replicas, err := newReplicas(cfg)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	c := &Client{cfg: cfg, log: logger, breaker: breaker.New(cfg.Breaker), replicas: replicas}
+	c.db.Store(db)
+	return c, nil
+}
+
+// breakerFailure counts errors that say the server is unhealthy. Data and
+// integrity errors (SQLSTATE classes 22 and 23) are the request's fault
+// and do not trip the breaker.
+func breakerFailure(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "22", "23":
+			return false
+		}
+	}
+	return breaker.DefaultIsFailure(err)
+}
+
+// Breaker returns the circuit breaker guarding queries, so callers can
+// react to it opening.
+func (c *Client) Breaker() *breaker.Breaker {
+	return c.breaker
+}
+
+// DSN renders the connection settings of cfg as a lib/pq key/value
+// connection string. Values are quoted, so passwords and paths may contain
+// spaces or quotes.
+func DSN(cfg Config) string {
+	params := []struct{ key, value string }{
+		{"host", cfg.Host},
+		{"port", cfg.Port},
+		{"user", cfg.User},
+		{"password", cfg.Password},
+		{"dbname", cfg.DBName},
+		{"sslmode", cfg.SSLMode},
+		{"sslrootcert", cfg.SSLRootCert},
+		{"sslcert", cfg.SSLCert},
+		{"sslkey", cfg.SSLKey},
+	}
+	var b strings.Builder
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(p.key)
+		b.WriteByte('=')
+		b.WriteString(dsnQuote(p.value))
+	}
+	return b.String()
+}
+
+func dsnQuote(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(v) + "'"
+}
+
+// newPool opens a connection pool for cfg without connecting.
+func newPool(cfg Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", DSN(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("postgres open: %w", err)
 	}
@@ -75,26 +204,99 @@ func NewPGClient(cfg Config) (*Client, error) {
 	db.SetMaxOpenConns(cfg.MaxOpenConns)
 	db.SetMaxIdleConns(cfg.MaxIdleConns)
 	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	return db, nil
+}
+
+// openPool opens and pings a connection pool for cfg.
+func openPool(cfg Config) (*sql.DB, error) {
+	db, err := newPool(cfg)
+	if err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.PingTimeout)
 	defer cancel()
 	if err := db.PingContext(ctx); err != nil {
 		_ = db.Close()
 		return nil, fmt.Errorf("postgres ping: %w", err)
 	}
+	return db, nil
+}
+
+func (c *Client) conn() *sql.DB {
+	return c.db.Load()
+}
+
+// UpdatePassword rebuilds the connection pool with a rotated password. The
+// new pool is verified before it replaces the old one, so a bad secret
+// leaves the client on the previous credentials. The old pool is closed
+// once operations that already picked it up have had time to finish.
+func (c *Client) UpdatePassword(password string) error {
+	c.rotateMu.Lock()
+	defer c.rotateMu.Unlock()
+
+	cfg := c.cfg
+	cfg.Password = password
+	db, err := openPool(cfg)
+	if err != nil {
+		c.inc("pg_pool_rebuild_total", map[string]string{"status": "error"})
+		return err
+	}
+	old := c.db.Swap(db)
+	c.log.Info("Connection pool rebuilt with rotated credentials")
+	c.inc("pg_pool_rebuild_total", map[string]string{"status": "success"})
+
+	grace := cfg.QueryTimeout + cfg.ExecTimeout
+	retire := func(old *sql.DB) {
+		time.AfterFunc(grace, func() {
+			if err := old.Close(); err != nil {
+				c.log.Warn("Failed to close previous pool", "error", err)
+			}
+		})
+	}
+	retire(old)
+
+	// Standbys share the primary's credentials. Their new pools are not
+	// verified here; the next health check does that.
+	for _, r := range c.replicas {
+		rdb, err := newPool(replicaConfig(cfg, r.addr))
+		if err != nil {
+			c.log.Warn("Failed to rebuild replica pool", "replica", r.addr, "error", err)
+			continue
+		}
+		retire(r.db.Swap(rdb))
+	}
+	return nil
+}
 
-	return &Client{db: db, cfg: cfg}, nil
+// Ping checks that the current pool can reach the server.
+func (c *Client) Ping(ctx context.Context) error {
+	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.PingTimeout)
+	defer cancel()
+	return c.conn().PingContext(ctx)
+}
+
+func (c *Client) inc(name string, labels map[string]string) {
+	if c.metrics == nil {
+		return
+	}
+	c.metrics.IncrementCounter(name, labels)
 }
 
 func (c *Client) SetMetricsRegistry(reg *metrics.Registry) {
 	c.metrics = reg
+	c.breaker.SetMetricsRegistry(reg)
 }
 
 func (c *Client) Close() error {
-	if c.db == nil {
+	for _, r := range c.replicas {
+		_ = r.db.Load().Close()
+	}
+	db := c.conn()
+	if db == nil {
 		return nil
 	}
-	if err := c.db.Close(); err != nil {
-		log.Printf("[POSTGRES] ERROR closing DB: %v", err)
+	if err := db.Close(); err != nil {
+		c.log.Error("Failed to close DB", "error", err)
 		return err
 	}
 	return nil
@@ -111,25 +313,82 @@ CREATE TABLE IF NOT EXISTS users (
 	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.ExecTimeout)
 	defer cancel()
 
-	_, err := c.db.ExecContext(ctx, q)
+	_, err := c.conn().ExecContext(ctx, q)
 	if err != nil {
-		log.Printf("[POSTGRES] ERROR creating table: %v", err)
+		c.log.ErrorContext(ctx, "Failed to create users table", "error", err)
 	}
 	return err
 }
 
-func (c *Client) SaveUser(ctx context.Context, userID string, jsonData string) error {
+// SaveUser upserts the user and reports whether its row was inserted
+// rather than updated.
+func (c *Client) SaveUser(ctx context.Context, userID string, jsonData string) (created bool, err error) {
+	if err := c.breaker.Allow(); err != nil {
+		return false, translate("pg.SaveUser", err)
+	}
+	ctx, span := startSpan(ctx, "SaveUser", "INSERT")
 	start := time.Now()
 	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.ExecTimeout)
 	defer cancel()
 
-	_, err := c.db.ExecContext(ctx, `
+	// The upsert is idempotent, so a retry after an ambiguous failure is
+	// safe, though it may then report the first attempt's insert as an
+	// update. xmax is 0 only on a row this statement inserted.
+	attempts, err := c.cfg.Retry.Do(ctx, func(ctx context.Context) error {
+		return c.conn().QueryRowContext(ctx, `
 INSERT INTO users (user_id, data) VALUES ($1, $2)
 ON CONFLICT (user_id) DO UPDATE SET data = EXCLUDED.data
-`, userID, jsonData)
+RETURNING (xmax = 0)
+`, userID, jsonData).Scan(&created)
+	})
 
-	c.observe("pg_save_user", err, time.Since(start))
-	return err
+	c.retried(ctx, "pg_save_user", attempts, err)
+	c.observe(ctx, "pg_save_user", nil, err, time.Since(start))
+	tracing.End(span, err)
+	return created, translate("pg.SaveUser", err)
+}
+
+// UpdateUser replaces an existing user's data. It returns ErrUserNotFound
+// if no user has the ID.
+func (c *Client) UpdateUser(ctx context.Context, userID string, jsonData string) error {
+	return c.changeUser(ctx, "UpdateUser", "UPDATE", "pg_update_user",
+		`UPDATE users SET data = $2 WHERE user_id = $1`, userID, jsonData)
+}
+
+// DeleteUser removes a user. It returns ErrUserNotFound if no user has the
+// ID, which after a retried ambiguous failure may mean this call deleted it.
+func (c *Client) DeleteUser(ctx context.Context, userID string) error {
+	return c.changeUser(ctx, "DeleteUser", "DELETE", "pg_delete_user",
+		`DELETE FROM users WHERE user_id = $1`, userID)
+}
+
+// changeUser runs a statement on one user's row on the primary, mapping
+// no affected rows to ErrUserNotFound.
+func (c *Client) changeUser(ctx context.Context, op, verb, metric, query string, args ...interface{}) error {
+	if err := c.breaker.Allow(); err != nil {
+		return translate("pg."+op, err)
+	}
+	ctx, span := startSpan(ctx, op, verb)
+	start := time.Now()
+	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.ExecTimeout)
+	defer cancel()
+
+	var rows int64
+	attempts, err := c.cfg.Retry.Do(ctx, func(ctx context.Context) error {
+		res, err := c.conn().ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		rows, err = res.RowsAffected()
+		return err
+	})
+	c.retried(ctx, metric, attempts, err)
+	c.observe(ctx, metric, nil, err, time.Since(start))
+	tracing.End(span, err)
+	if err == nil && rows == 0 {
+		return ErrUserNotFound
+	}
+	return translate("pg."+op, err)
 }
 
 type StoredUser struct {
@@ -137,36 +396,135 @@ type StoredUser struct {
 	Data   string `json:"data"`
 }
 
-func (c *Client) GetUsers(ctx context.Context) ([]StoredUser, error) {
+func (c *Client) GetUsers(ctx context.Context) (users []StoredUser, err error) {
+	db, rep := c.reader()
+	if rep == nil {
+		if err := c.breaker.Allow(); err != nil {
+			return nil, translate("pg.GetUsers", err)
+		}
+	}
+	ctx, span := startSpan(ctx, "GetUsers", "SELECT")
+	span.SetAttributes(attribute.String("db.pg.target", target(rep)))
+	defer func() {
+		tracing.End(span, err)
+		err = translate("pg.GetUsers", err)
+	}()
 	start := time.Now()
 	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.QueryTimeout)
 	defer cancel()
 
-	rows, err := c.db.QueryContext(ctx, `SELECT user_id, data::text FROM users ORDER BY created_at DESC LIMIT 1000`)
+	var rows *sql.Rows
+	attempts, err := c.cfg.Retry.Do(ctx, func(ctx context.Context) error {
+		var err error
+		rows, err = db.QueryContext(ctx, `SELECT user_id, data::text FROM users ORDER BY created_at DESC LIMIT 1000`)
+		return err
+	})
+	c.retried(ctx, "pg_get_users", attempts, err)
 	if err != nil {
-		c.observe("pg_get_users", err, time.Since(start))
+		c.observe(ctx, "pg_get_users", rep, err, time.Since(start))
 		return nil, err
 	}
 	defer rows.Close()
 
-	users := make([]StoredUser, 0, 128)
+	users = make([]StoredUser, 0, 128)
 	for rows.Next() {
 		var u StoredUser
 		if err := rows.Scan(&u.UserID, &u.Data); err != nil {
-			c.observe("pg_get_users", err, time.Since(start))
+			c.observe(ctx, "pg_get_users", rep, err, time.Since(start))
 			return nil, err
 		}
 		users = append(users, u)
 	}
 	if err := rows.Err(); err != nil {
-		c.observe("pg_get_users", err, time.Since(start))
+		c.observe(ctx, "pg_get_users", rep, err, time.Since(start))
 		return nil, err
 	}
 
-	c.observe("pg_get_users", nil, time.Since(start))
+	c.observe(ctx, "pg_get_users", rep, nil, time.Since(start))
 	return users, nil
 }
-func (c *Client) observe(op string, err error, d time.Duration) {
+
+// ErrUserNotFound is returned by GetUser, UpdateUser and DeleteUser when no
+// user has the ID.
+var ErrUserNotFound = apperr.New(apperr.NotFound, "user not found")
+
+// GetUser returns one user, from a replica when one is healthy.
+func (c *Client) GetUser(ctx context.Context, userID string) (_ *StoredUser, err error) {
+	db, rep := c.reader()
+	if rep == nil {
+		if err := c.breaker.Allow(); err != nil {
+			return nil, translate("pg.GetUser", err)
+		}
+	}
+	ctx, span := startSpan(ctx, "GetUser", "SELECT")
+	span.SetAttributes(attribute.String("db.pg.target", target(rep)))
+	defer func() {
+		tracing.End(span, err)
+		err = translate("pg.GetUser", err)
+	}()
+	start := time.Now()
+	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.QueryTimeout)
+	defer cancel()
+
+	u := StoredUser{UserID: userID}
+	attempts, err := c.cfg.Retry.Do(ctx, func(ctx context.Context) error {
+		return db.QueryRowContext(ctx, `SELECT data::text FROM users WHERE user_id = $1`, userID).Scan(&u.Data)
+	})
+	c.retried(ctx, "pg_get_user", attempts, err)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.observe(ctx, "pg_get_user", rep, nil, time.Since(start))
+		return nil, ErrUserNotFound
+	}
+	c.observe(ctx, "pg_get_user", rep, err, time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+func startSpan(ctx context.Context, op, verb string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "pg."+op, trace.SpanKindClient,
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", verb),
+	)
+}
+
+// retried records the retries spent on op, if any.
+func (c *Client) retried(ctx context.Context, op string, attempts int, err error) {
+	if attempts <= 1 {
+		return
+	}
+	if err != nil {
+		c.log.WarnContext(ctx, "Query failed after retries", "op", op, "attempts", attempts, "error", err)
+	} else {
+		c.log.InfoContext(ctx, "Query succeeded after retries", "op", op, "attempts", attempts)
+	}
+	if c.metrics == nil {
+		return
+	}
+	outcome := "recovered"
+	if err != nil {
+		outcome = "failed"
+	}
+	c.metrics.AddCounter("pg_retries_total", float64(attempts-1), map[string]string{"op": op})
+	c.metrics.IncrementCounter("pg_retried_operations_total", map[string]string{"op": op, "outcome": outcome})
+}
+
+// observe records op's outcome and logs it under the caller's context so
+// failures can be tied to the delivery or request that issued them. rep is
+// the replica that served op, nil for the primary: primary outcomes feed
+// the breaker, while a replica that fails to connect leaves the rotation.
+func (c *Client) observe(ctx context.Context, op string, rep *replica, err error, d time.Duration) {
+	tgt := target(rep)
+	if rep == nil {
+		c.breaker.Record(err)
+	} else if Retryable(err) {
+		c.setReplicaHealth(rep, false, "error", err)
+	}
+	if err != nil {
+		c.log.WarnContext(ctx, "Query failed", "op", op, "target", tgt, "error", err, "duration_ms", d.Milliseconds())
+	} else {
+		c.log.DebugContext(ctx, "Query completed", "op", op, "target", tgt, "duration_ms", d.Milliseconds())
+	}
 	if c.metrics == nil {
 		return
 	}
@@ -174,8 +532,8 @@ func (c *Client) observe(op string, err error, d time.Duration) {
 	if err != nil {
 		status = "error"
 	}
-	c.metrics.IncrementCounter(op+"_total", map[string]string{"status": status})
-	c.metrics.SetGauge(op+"_duration_seconds", d.Seconds(), map[string]string{"stat": "last"})
+	c.metrics.IncrementCounter(op+"_total", map[string]string{"status": status, "target": tgt})
+	c.metrics.SetGauge(op+"_duration_seconds", d.Seconds(), map[string]string{"stat": "last", "target": tgt})
 }
 
 func withTimeoutIfNone(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
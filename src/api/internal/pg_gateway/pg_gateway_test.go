@@ -0,0 +1,70 @@
+package pg_gateway
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"api/internal/logging"
+)
+
+// closedPort returns a local port nothing listens on.
+func closedPort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	return port
+}
+
+func TestDSNQuotes(t *testing.T) {
+	got := DSN(Config{Host: "db", User: "app", Password: `it's a \secret`, SSLMode: "disable"})
+	want := `host='db' user='app' password='it\'s a \\secret' sslmode='disable'`
+	if got != want {
+		t.Errorf("DSN = %s, want %s", got, want)
+	}
+}
+
+func TestConnectFailureDoesNotLogPassword(t *testing.T) {
+	const password = `it's a s3cret`
+	var buf bytes.Buffer
+	logger := logging.New(logging.Options{
+		Writer:   &buf,
+		Level:    slog.LevelDebug,
+		Redactor: logging.NewRedactor(logging.DefaultRules(), "key"),
+	})
+
+	cfg := Config{
+		Host:        "127.0.0.1",
+		Port:        closedPort(t),
+		User:        "app",
+		Password:    password,
+		DBName:      "app",
+		PingTimeout: 2 * time.Second,
+		Logger:      logger,
+	}
+	_, err := NewPGClient(cfg)
+	if err == nil {
+		t.Fatal("NewPGClient succeeded against a closed port")
+	}
+	// What main does with the error, plus the DSN itself as it would show
+	// up if a driver error quoted it.
+	logger.Error("Failed to connect to Postgres", "error", err)
+	logger.Error("Failed to connect to Postgres", "error", "pq: bad connection string: "+DSN(cfg))
+
+	out := buf.String()
+	if !strings.Contains(out, "Opening connection") {
+		t.Fatalf("no connection log captured: %s", out)
+	}
+	for _, s := range []string{password, "s3cret", `\'s`} {
+		if strings.Contains(out, s) {
+			t.Errorf("log output leaks %q:\n%s", s, out)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package pg_gateway
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const primaryTarget = "primary"
+
+// replica is a read-only standby. Its pool connects lazily; Run keeps
+// healthy current, and reads only go to it while healthy is set.
+type replica struct {
+	addr    string // host:port, also the metrics target label
+	db      atomic.Pointer[sql.DB]
+	healthy atomic.Bool
+}
+
+// replicaConfig returns cfg pointed at addr, which may omit the port.
+func replicaConfig(cfg Config, addr string) Config {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		cfg.Host, cfg.Port = host, port
+	} else {
+		cfg.Host = addr
+	}
+	return cfg
+}
+
+func newReplicas(cfg Config) ([]*replica, error) {
+	out := make([]*replica, 0, len(cfg.Replicas))
+	for _, addr := range cfg.Replicas {
+		rcfg := replicaConfig(cfg, addr)
+		db, err := newPool(rcfg)
+		if err != nil {
+			for _, r := range out {
+				_ = r.db.Load().Close()
+			}
+			return nil, err
+		}
+		r := &replica{addr: net.JoinHostPort(rcfg.Host, rcfg.Port)}
+		r.db.Store(db)
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// reader picks the pool for a read-only query: the next healthy replica in
+// round-robin order, or the primary (rep == nil) when none is healthy.
+func (c *Client) reader() (db *sql.DB, rep *replica) {
+	n := len(c.replicas)
+	if n == 0 {
+		return c.conn(), nil
+	}
+	start := int(c.nextReplica.Add(1) % uint64(n))
+	for i := 0; i < n; i++ {
+		r := c.replicas[(start+i)%n]
+		if r.healthy.Load() {
+			return r.db.Load(), r
+		}
+	}
+	return c.conn(), nil
+}
+
+func target(rep *replica) string {
+	if rep == nil {
+		return primaryTarget
+	}
+	return rep.addr
+}
+
+// Run health-checks the replicas every ReplicaCheckInterval until ctx is
+// cancelled. A replica serves reads only while it answers and its
+// replication lag is within MaxReplicationLag. Without replicas Run
+// returns immediately.
+func (c *Client) Run(ctx context.Context) {
+	if len(c.replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(c.cfg.ReplicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		for _, r := range c.replicas {
+			c.checkReplica(ctx, r)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replicaLagQuery reports how far replay is behind. A standby that has
+// replayed everything it received is current however old its last
+// transaction is; a server not in recovery has no lag.
+const replicaLagQuery = `
+SELECT COALESCE(
+    CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+         ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+    END, 0)`
+
+func (c *Client) checkReplica(ctx context.Context, r *replica) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.PingTimeout)
+	defer cancel()
+
+	var lagSeconds float64
+	err := r.db.Load().QueryRowContext(ctx, replicaLagQuery).Scan(&lagSeconds)
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	lag := time.Duration(lagSeconds * float64(time.Second))
+	switch {
+	case err != nil:
+		c.setReplicaHealth(r, false, "error", err)
+	case lag > c.cfg.MaxReplicationLag:
+		c.setReplicaHealth(r, false, "lagging", nil, "lag", lag, "max_lag", c.cfg.MaxReplicationLag)
+	default:
+		c.setReplicaHealth(r, true, "", nil)
+	}
+	if c.metrics != nil && err == nil {
+		c.metrics.SetGauge("pg_replica_lag_seconds", lag.Seconds(), map[string]string{"replica": r.addr})
+	}
+}
+
+// setReplicaHealth records r's state, logging only transitions.
+func (c *Client) setReplicaHealth(r *replica, healthy bool, reason string, err error, attrs ...interface{}) {
+	if r.healthy.Swap(healthy) != healthy {
+		if healthy {
+			c.log.Info("Replica healthy, routing reads to it", "replica", r.addr)
+		} else {
+			args := append([]interface{}{"replica", r.addr, "reason", reason}, attrs...)
+			if err != nil {
+				args = append(args, "error", err)
+			}
+			c.log.Warn("Replica unhealthy, routing reads elsewhere", args...)
+		}
+	}
+	if c.metrics != nil {
+		v := 0.0
+		if healthy {
+			v = 1
+		}
+		c.metrics.SetGauge("pg_replica_healthy", v, map[string]string{"replica": r.addr})
+	}
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"api/internal/apperr"
+)
+
+// APIKeyHeader identifies a client more precisely than its address.
+const APIKeyHeader = "X-API-Key"
+
+// ClientKey identifies r's client: by a hash of its API key if it sent
+// one, otherwise by its remote IP. Forwarding headers are not trusted, as
+// any client can set them.
+func ClientKey(r *http.Request) string {
+	if k := r.Header.Get(APIKeyHeader); k != "" {
+		// Keep the key itself out of Redis.
+		sum := sha256.Sum256([]byte(k))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Middleware rejects requests over l's limit with 429 and a Retry-After
+// header. key picks the limit's key for a request; nil means ClientKey.
+func Middleware(l *Limiter, key func(*http.Request) string, next http.Handler) http.Handler {
+	if key == nil {
+		key = ClientKey
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := l.Allow(r.Context(), key(r))
+		if l.Limit() > 0 {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.Limit()))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		}
+		if res.Allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": ErrLimited.Error(), "code": apperr.RateLimited.Code()})
+	})
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// local is the in-memory token bucket used while Redis is unavailable.
+type local struct {
+	rate     float64 // tokens per millisecond
+	capacity float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	ts     time.Time
+}
+
+func newLocal(rate, capacity float64) *local {
+	return &local{rate: rate, capacity: capacity, buckets: make(map[string]*bucket)}
+}
+
+// full is how long an idle bucket takes to refill, after which it is no
+// different from a missing one.
+func (l *local) full() time.Duration {
+	return time.Duration(math.Ceil(l.capacity/l.rate)) * time.Millisecond
+}
+
+func (l *local) allow(key string, now time.Time) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.capacity, ts: now}
+		l.buckets[key] = b
+	}
+	elapsed := float64(now.Sub(b.ts)) / float64(time.Millisecond)
+	b.tokens = math.Min(l.capacity, b.tokens+math.Max(0, elapsed)*l.rate)
+	b.ts = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return Result{Allowed: true, Remaining: int(b.tokens)}
+	}
+	wait := math.Ceil((1 - b.tokens) / l.rate)
+	return Result{RetryAfter: time.Duration(wait) * time.Millisecond}
+}
+
+// sweep drops refilled buckets so keys seen once do not accumulate. It
+// runs at most once per refill period.
+func (l *local) sweep(now time.Time) {
+	full := l.full()
+	if now.Sub(l.lastSweep) < full {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if now.Sub(b.ts) >= full {
+			delete(l.buckets, k)
+		}
+	}
+}
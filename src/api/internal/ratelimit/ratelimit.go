@@ -0,0 +1,238 @@
+// Package ratelimit limits how often a key (a client, an API key, a queue)
+// may do something, shared across replicas through Redis.
+//
+// Decisions are made by Lua scripts so that the check and the update are
+// atomic: a sliding window log, exact but storing one entry per request,
+// or a token bucket, which stores two numbers per key and allows bursts.
+// While Redis is unavailable each replica falls back to an in-memory token
+// bucket with the same settings, so the effective limit is per replica
+// until Redis returns.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"api/internal/apperr"
+	"api/internal/logging"
+	"api/internal/metrics"
+	"api/internal/redis_gateway"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Algorithms for Config.Algorithm.
+const (
+	SlidingWindow = "sliding_window"
+	TokenBucket   = "token_bucket"
+)
+
+// ErrLimited is returned by Wait when ctx ends before the limit allows.
+var ErrLimited = apperr.New(apperr.RateLimited, "rate limit exceeded")
+
+// Both scripts read the clock with TIME so replicas with skewed clocks
+// agree, and reply {allowed, remaining, retry_after_ms}.
+var slidingWindowScript = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return {1, limit - count - 1, 0}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {0, 0, math.max(1, tonumber(oldest[2]) + window - now)}
+`)
+
+var tokenBucketScript = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1]) or capacity
+local ts = tonumber(b[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity / rate) + 1000)
+return {allowed, math.floor(tokens), wait}
+`)
+
+type Config struct {
+	// Name identifies the limiter in Redis keys and metrics labels.
+	Name string
+	// Algorithm is SlidingWindow or TokenBucket. Defaults to TokenBucket.
+	Algorithm string
+	// Limit is how many requests a key may make per Window. 0 disables
+	// the limiter: every request is allowed.
+	Limit int
+	// Window defaults to 1s.
+	Window time.Duration
+	// Burst is the token bucket's capacity. Defaults to Limit; ignored by
+	// SlidingWindow.
+	Burst int
+
+	Logger *slog.Logger
+}
+
+// Result is the outcome of one Allow call.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next request would be allowed; zero
+	// when Allowed.
+	RetryAfter time.Duration
+}
+
+type Limiter struct {
+	cfg     Config
+	redis   *redis_gateway.Client
+	local   *local
+	metrics *metrics.Registry
+	log     *slog.Logger
+}
+
+func New(r *redis_gateway.Client, cfg Config) (*Limiter, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("ratelimit: name is required")
+	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = TokenBucket
+	}
+	if cfg.Algorithm != TokenBucket && cfg.Algorithm != SlidingWindow {
+		return nil, fmt.Errorf("ratelimit: unknown algorithm %q", cfg.Algorithm)
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Second
+	}
+	if cfg.Window < time.Millisecond {
+		return nil, fmt.Errorf("ratelimit: window %v is below the 1ms resolution", cfg.Window)
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = cfg.Limit
+	}
+	return &Limiter{
+		cfg:   cfg,
+		redis: r,
+		local: newLocal(cfg.rate(), float64(cfg.Burst)),
+		log:   logging.Component(cfg.Logger, "ratelimit").With("limiter", cfg.Name),
+	}, nil
+}
+
+// rate is the token refill rate per millisecond.
+func (c Config) rate() float64 {
+	return float64(c.Limit) / float64(c.Window.Milliseconds())
+}
+
+func (l *Limiter) SetMetricsRegistry(reg *metrics.Registry) {
+	l.metrics = reg
+}
+
+// Limit returns the configured requests per Window.
+func (l *Limiter) Limit() int {
+	return l.cfg.Limit
+}
+
+// Allow decides whether key may make one more request now and records it
+// if so. It does not fail: if Redis cannot decide, the local fallback
+// does.
+func (l *Limiter) Allow(ctx context.Context, key string) Result {
+	if l.cfg.Limit <= 0 {
+		return Result{Allowed: true}
+	}
+	res, err := l.allowRedis(ctx, key)
+	if err != nil {
+		if !errors.Is(err, redis_gateway.ErrUnavailable) {
+			l.log.WarnContext(ctx, "Rate limit check failed, using local limiter", "error", err)
+		}
+		l.inc("ratelimit_fallback_total", map[string]string{"limiter": l.cfg.Name, "code": apperr.Code(err)})
+		res = l.local.allow(key, time.Now())
+	}
+	result := "allowed"
+	if !res.Allowed {
+		result = "limited"
+	}
+	l.inc("ratelimit_requests_total", map[string]string{"limiter": l.cfg.Name, "result": result})
+	return res
+}
+
+func (l *Limiter) allowRedis(ctx context.Context, key string) (Result, error) {
+	if l.redis == nil || !l.redis.Available() {
+		return Result{}, redis_gateway.ErrUnavailable
+	}
+	rkey := "ratelimit:" + l.cfg.Name + ":" + key
+	var vals []int64
+	var err error
+	switch l.cfg.Algorithm {
+	case SlidingWindow:
+		// The member only has to be unique within the window.
+		member := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatInt(rand.Int63(), 36)
+		vals, err = l.redis.RunScript(ctx, "RATELIMIT", slidingWindowScript, []string{rkey},
+			l.cfg.Window.Milliseconds(), l.cfg.Limit, member)
+	default:
+		vals, err = l.redis.RunScript(ctx, "RATELIMIT", tokenBucketScript, []string{rkey},
+			strconv.FormatFloat(l.cfg.rate(), 'g', -1, 64), l.cfg.Burst)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	if len(vals) != 3 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected reply of %d values", len(vals))
+	}
+	return Result{
+		Allowed:    vals[0] == 1,
+		Remaining:  int(vals[1]),
+		RetryAfter: time.Duration(vals[2]) * time.Millisecond,
+	}, nil
+}
+
+// Wait blocks until key is allowed a request, returning ErrLimited if ctx
+// ends first.
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	start := time.Now()
+	for {
+		res := l.Allow(ctx, key)
+		if res.Allowed {
+			if waited := time.Since(start); waited > 0 && l.metrics != nil {
+				l.metrics.AddCounter("ratelimit_wait_seconds_total", waited.Seconds(), map[string]string{"limiter": l.cfg.Name})
+			}
+			return nil
+		}
+		wait := res.RetryAfter
+		if wait < time.Millisecond {
+			wait = time.Millisecond
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w: %w", ErrLimited, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+func (l *Limiter) inc(name string, labels map[string]string) {
+	if l.metrics == nil {
+		return
+	}
+	l.metrics.IncrementCounter(name, labels)
+}
@@ -0,0 +1,75 @@
+package redis_gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"api/internal/apperr"
+	"api/internal/breaker"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// retryablePrefixes are server replies that mean "not now" rather than
+// "never": a replica still loading its dataset, a node demoted by a
+// failover, or a cluster in the middle of resharding.
+var retryablePrefixes = []string{"LOADING ", "READONLY ", "TRYAGAIN ", "CLUSTERDOWN ", "MASTERDOWN "}
+
+// Retryable reports whether err is transient, so the same command may
+// succeed if sent again. Timeouts are not retried: the server is slow, and
+// retrying would only add load (the breaker handles that case).
+func Retryable(err error) bool {
+	if err == nil || err == redis.Nil || errors.Is(err, ErrUnavailable) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return !netErr.Timeout()
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		msg := redisErr.Error()
+		for _, p := range retryablePrefixes {
+			if strings.HasPrefix(msg, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// translate maps err to an apperr kind, keeping it as the cause. A miss
+// (redis.Nil) becomes NotFound.
+func translate(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var appErr *apperr.Error
+	if errors.As(err, &appErr) {
+		return err
+	}
+	kind := apperr.Internal
+	switch {
+	case err == redis.Nil:
+		kind = apperr.NotFound
+	case errors.Is(err, breaker.ErrCircuitOpen):
+		kind = apperr.Unavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		kind = apperr.Timeout
+	case Retryable(err):
+		kind = apperr.Unavailable
+	default:
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			kind = apperr.Timeout
+		}
+	}
+	return apperr.E(kind, op, err)
+}
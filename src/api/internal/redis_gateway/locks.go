@@ -0,0 +1,366 @@
+package redis_gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"api/internal/apperr"
+	"api/internal/retry"
+	"api/internal/tracing"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Lock errors. Both are apperr.Conflict: someone else has the lock.
+var (
+	// ErrLockHeld is returned when the lock is taken, or was still taken
+	// when the caller's context ended.
+	ErrLockHeld = apperr.New(apperr.Conflict, "redis: lock held by another owner")
+	// ErrLockLost is returned by Extend and Release when the lock expired
+	// and may since have been taken by another owner.
+	ErrLockLost = apperr.New(apperr.Conflict, "redis: lock lost")
+)
+
+// The lock key holds the owner's random token; the fence key, in the same
+// hash slot, counts acquisitions. Acquiring again with the same token
+// returns the current fence, so a retried acquire is harmless.
+var acquireScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur == ARGV[1] then
+	return tonumber(redis.call('GET', KEYS[2]))
+end
+if cur then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return redis.call('INCR', KEYS[2])
+`)
+
+var extendScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+type LockOptions struct {
+	// TTL bounds how long the lock outlives a holder that dies without
+	// releasing it. Defaults to 30s.
+	TTL time.Duration
+	// RetryInterval is the mean delay between attempts while AcquireLock
+	// waits. Defaults to 100ms.
+	RetryInterval time.Duration
+	// AutoExtend pushes the expiry back by TTL every TTL/3 until Release.
+	// If that keeps failing until the lock may have expired, Lost is
+	// closed.
+	AutoExtend bool
+}
+
+func (o LockOptions) withDefaults() LockOptions {
+	if o.TTL <= 0 {
+		o.TTL = 30 * time.Second
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = 100 * time.Millisecond
+	}
+	return o
+}
+
+// Lock is a held distributed lock. Work it guards should pass Fence to
+// whatever it writes, so a store can reject writes from a holder whose
+// lock has since expired and been taken by someone else.
+type Lock struct {
+	c        *Client
+	name     string
+	key      string
+	token    string
+	fence    int64
+	ttl      time.Duration
+	acquired time.Time
+
+	lost     chan struct{}
+	lostOnce sync.Once
+
+	stop        chan struct{}
+	done        chan struct{}
+	releaseOnce sync.Once
+	releaseErr  error
+}
+
+// TryAcquireLock makes a single attempt at the lock called name and returns
+// ErrLockHeld if it is taken. name is used as a metrics label and should
+// come from a small fixed set.
+func (c *Client) TryAcquireLock(ctx context.Context, name string, opts LockOptions) (*Lock, error) {
+	opts = opts.withDefaults()
+	l := c.newLock(name, opts)
+	ok, err := l.attempt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLockHeld
+	}
+	l.start(opts, 0)
+	return l, nil
+}
+
+// AcquireLock waits for the lock called name until ctx ends, then returns
+// ErrLockHeld. Errors other than contention end the wait at once.
+func (c *Client) AcquireLock(ctx context.Context, name string, opts LockOptions) (*Lock, error) {
+	opts = opts.withDefaults()
+	l := c.newLock(name, opts)
+	began := time.Now()
+	for {
+		ok, err := l.attempt(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			l.start(opts, time.Since(began))
+			return l, nil
+		}
+		// Jitter keeps waiters from polling in lockstep.
+		wait := opts.RetryInterval/2 + time.Duration(rand.Int63n(int64(opts.RetryInterval)+1))
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, fmt.Errorf("%w: %w", ErrLockHeld, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+func (c *Client) newLock(name string, opts LockOptions) *Lock {
+	return &Lock{
+		c:     c,
+		name:  name,
+		key:   "lock:{" + name + "}",
+		token: uuid.NewString(),
+		ttl:   opts.TTL,
+		lost:  make(chan struct{}),
+	}
+}
+
+// attempt tries once to take the lock, reporting whether it did.
+func (l *Lock) attempt(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.c.cfg.OpTimeout)
+	defer cancel()
+	fence, err := l.c.script(ctx, "LOCK", acquireScript, l.c.cfg.Retry, []string{l.key, l.key + ":fence"}, l.token, l.ttl.Milliseconds())
+	status := "acquired"
+	switch {
+	case err != nil:
+		status = "error"
+	case fence == 0:
+		status = "contended"
+	}
+	l.c.inc("redis_lock_acquire_total", map[string]string{"lock": l.name, "status": status})
+	if err != nil {
+		return false, err
+	}
+	l.fence = fence
+	return fence != 0, nil
+}
+
+func (l *Lock) start(opts LockOptions, waited time.Duration) {
+	l.acquired = time.Now()
+	l.c.log.Debug("Lock acquired", "lock", l.name, "fence", l.fence, "waited_ms", waited.Milliseconds())
+	if l.c.metrics != nil {
+		l.c.metrics.SetGauge("redis_lock_wait_seconds", waited.Seconds(), map[string]string{"lock": l.name, "stat": "last"})
+		l.c.metrics.SetGauge("redis_lock_held", 1, map[string]string{"lock": l.name})
+	}
+	if opts.AutoExtend {
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.extendLoop()
+	}
+}
+
+// Name returns the name the lock was acquired under.
+func (l *Lock) Name() string {
+	return l.name
+}
+
+// Fence returns the fencing token: it increases with every acquisition of
+// the same name, so a larger value always belongs to a later holder.
+func (l *Lock) Fence() int64 {
+	return l.fence
+}
+
+// Lost is closed when the lock is found to have expired while held. Work
+// guarded by the lock should stop when it is.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Extend resets the lock's expiry to TTL from now. It returns ErrLockLost,
+// and closes Lost, if the lock is no longer ours.
+func (l *Lock) Extend(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, l.c.cfg.OpTimeout)
+	defer cancel()
+	n, err := l.c.script(ctx, "PEXPIRE", extendScript, l.c.cfg.Retry, []string{l.key}, l.token, l.ttl.Milliseconds())
+	status := "success"
+	switch {
+	case err != nil:
+		status = "error"
+	case n == 0:
+		status = "lost"
+		err = ErrLockLost
+		l.markLost(err)
+	}
+	l.c.inc("redis_lock_extend_total", map[string]string{"lock": l.name, "status": status})
+	return err
+}
+
+func (l *Lock) extendLoop() {
+	defer close(l.done)
+	t := time.NewTicker(l.ttl / 3)
+	defer t.Stop()
+	// As far as we know the lock is ours until expires; past it, another
+	// owner may have taken it even if Redis is unreachable to us.
+	expires := l.acquired.Add(l.ttl)
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-l.lost:
+			return
+		case <-t.C:
+		}
+		sent := time.Now()
+		err := l.Extend(context.Background())
+		switch {
+		case err == nil:
+			expires = sent.Add(l.ttl)
+		case errors.Is(err, ErrLockLost):
+			return
+		case time.Now().After(expires):
+			l.markLost(err)
+			return
+		default:
+			l.c.log.Warn("Failed to extend lock", "lock", l.name, "error", err)
+		}
+	}
+}
+
+func (l *Lock) markLost(err error) {
+	l.lostOnce.Do(func() {
+		l.c.log.Warn("Lock lost while held", "lock", l.name, "fence", l.fence, "error", err)
+		l.c.inc("redis_lock_lost_total", map[string]string{"lock": l.name})
+		if l.c.metrics != nil {
+			l.c.metrics.SetGauge("redis_lock_held", 0, map[string]string{"lock": l.name})
+		}
+		close(l.lost)
+	})
+}
+
+// Release stops auto-extension and deletes the lock if it is still ours,
+// returning ErrLockLost if it was not. Calls after the first return the
+// first call's result.
+func (l *Lock) Release(ctx context.Context) error {
+	l.releaseOnce.Do(func() {
+		if l.stop != nil {
+			close(l.stop)
+			<-l.done
+		}
+		ctx, cancel := context.WithTimeout(ctx, l.c.cfg.OpTimeout)
+		defer cancel()
+		// Not retried: after an ambiguous failure the key may already be
+		// gone, and a second attempt would report the lock lost.
+		n, err := l.c.script(ctx, "UNLOCK", releaseScript, retry.Policy{MaxAttempts: 1}, []string{l.key}, l.token)
+		if err == nil && n == 0 {
+			err = ErrLockLost
+			l.markLost(err)
+		}
+		held := time.Since(l.acquired)
+		l.c.log.Debug("Lock released", "lock", l.name, "fence", l.fence, "held_ms", held.Milliseconds(), "error", err)
+		if l.c.metrics != nil {
+			labels := map[string]string{"lock": l.name}
+			l.c.metrics.SetGauge("redis_lock_held", 0, labels)
+			l.c.metrics.AddCounter("redis_lock_hold_seconds_total", held.Seconds(), labels)
+			l.c.metrics.SetGauge("redis_lock_hold_seconds", held.Seconds(), map[string]string{"lock": l.name, "stat": "last"})
+		}
+		l.releaseErr = err
+	})
+	return l.releaseErr
+}
+
+// script runs s under the same guards as Set and Get (degraded-mode skip,
+// breaker, retries) and returns its integer reply.
+func (c *Client) script(ctx context.Context, cmd string, s *redis.Script, policy retry.Policy, keys []string, args ...interface{}) (int64, error) {
+	v, err := c.eval(ctx, cmd, s, policy, keys, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, translate("redis."+cmd, fmt.Errorf("unexpected reply %T", v))
+	}
+	return n, nil
+}
+
+// RunScript runs s once under the same guards as Set and Get and returns
+// its reply, which must be an array of integers. It is not retried, as
+// scripts need not be idempotent. cmd names the script in logs, spans and
+// metrics.
+func (c *Client) RunScript(ctx context.Context, cmd string, s *redis.Script, keys []string, args ...interface{}) ([]int64, error) {
+	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.OpTimeout)
+	defer cancel()
+	v, err := c.eval(ctx, cmd, s, retry.Policy{MaxAttempts: 1}, keys, args...)
+	if err != nil {
+		return nil, err
+	}
+	vals, ok := v.([]interface{})
+	if !ok {
+		return nil, translate("redis."+cmd, fmt.Errorf("unexpected reply %T", v))
+	}
+	out := make([]int64, len(vals))
+	for i, x := range vals {
+		if out[i], ok = x.(int64); !ok {
+			return nil, translate("redis."+cmd, fmt.Errorf("unexpected reply element %T", x))
+		}
+	}
+	return out, nil
+}
+
+func (c *Client) eval(ctx context.Context, cmd string, s *redis.Script, policy retry.Policy, keys []string, args ...interface{}) (interface{}, error) {
+	if c.skip(cmd) {
+		return nil, ErrUnavailable
+	}
+	op := "redis." + cmd
+	if err := c.breaker.Allow(); err != nil {
+		return nil, translate(op, err)
+	}
+	ctx, span := startSpan(ctx, cmd, keys[0])
+	if policy.Retryable == nil {
+		policy.Retryable = Retryable
+	}
+	var v interface{}
+	attempts, err := policy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		v, err = s.Run(ctx, c.client(), keys, args...).Result()
+		return err
+	})
+	c.retried(ctx, cmd, attempts, err)
+	c.breaker.Record(err)
+	if connectionError(err) {
+		c.markDown(err)
+	}
+	if err != nil {
+		c.log.WarnContext(ctx, cmd+" failed", "key", keys[0], "error", err)
+	}
+	tracing.End(span, err)
+	return v, translate(op, err)
+}
@@ -0,0 +1,101 @@
+package redis_gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"api/internal/tracing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Publish sends payload to channel's current subscribers. Messages are not
+// stored: a subscriber that is disconnected misses them.
+func (c *Client) Publish(ctx context.Context, channel, payload string) error {
+	if c.skip("PUBLISH") {
+		return ErrUnavailable
+	}
+	if err := c.breaker.Allow(); err != nil {
+		return translate("redis.PUBLISH", err)
+	}
+	ctx, span := startSpan(ctx, "PUBLISH", channel)
+	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.OpTimeout)
+	defer cancel()
+
+	attempts, err := c.cfg.Retry.Do(ctx, func(ctx context.Context) error {
+		return c.client().Publish(ctx, channel, payload).Err()
+	})
+	c.retried(ctx, "PUBLISH", attempts, err)
+	c.breaker.Record(err)
+	if connectionError(err) {
+		c.markDown(err)
+	}
+	if err != nil {
+		c.log.WarnContext(ctx, "PUBLISH failed", "channel", channel, "error", err)
+	}
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	c.inc("redis_publish_total", map[string]string{"channel": channel, "status": status})
+	tracing.End(span, err)
+	return translate("redis.PUBLISH", err)
+}
+
+// Subscribe calls handle with each message published on channel until ctx
+// is cancelled, resubscribing every ReconnectInterval after a failure or a
+// credential rotation. onSubscribe, if non-nil, runs whenever the
+// subscription is (re)established: anything published while it was down
+// was missed, so state kept in sync by the messages should be dropped.
+func (c *Client) Subscribe(ctx context.Context, channel string, handle func(payload string), onSubscribe func()) {
+	for ctx.Err() == nil {
+		err := c.receive(ctx, channel, handle, onSubscribe)
+		if ctx.Err() != nil {
+			return
+		}
+		c.inc("redis_subscribe_errors_total", map[string]string{"channel": channel})
+		c.log.Debug("Subscription interrupted", "channel", channel, "error", err)
+		t := time.NewTimer(c.cfg.ReconnectInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// receive runs one subscription on the current client until it fails,
+// which includes the client being closed by UpdatePassword.
+func (c *Client) receive(ctx context.Context, channel string, handle func(string), onSubscribe func()) error {
+	ps := c.client().Subscribe(ctx, channel)
+	defer ps.Close()
+	for {
+		msg, err := ps.ReceiveTimeout(ctx, c.cfg.ReconnectInterval)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil {
+				// A quiet channel; make sure the connection is still
+				// there rather than wait on a dead one forever.
+				if err := ps.Ping(ctx); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind == "subscribe" {
+				c.log.Info("Subscribed", "channel", channel)
+				if onSubscribe != nil {
+					onSubscribe()
+				}
+			}
+		case *redis.Message:
+			handle(m.Payload)
+		}
+	}
+}
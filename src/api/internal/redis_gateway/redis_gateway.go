@@ -2,18 +2,49 @@ package redis_gateway
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"api/internal/apperr"
+	"api/internal/breaker"
+	"api/internal/logging"
 	"api/internal/metrics"
+	"api/internal/retry"
+	"api/internal/tracing"
 
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Deployment modes for Config.Mode.
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
 )
 
 type Config struct {
-	Addr         string
-	Password     string
+	// Mode selects the deployment: standalone (Addr), sentinel (MasterName
+	// resolved through the sentinels in Addrs) or cluster (seed nodes in
+	// Addrs). Defaults to standalone.
+	Mode             string
+	Addr             string
+	Addrs            []string
+	MasterName       string
+	SentinelPassword string
+
+	Password string
+	// TLS, when set, encrypts connections to every node, sentinels
+	// included.
+	TLS          *tls.Config
 	DB           int
 	DialTimeout  time.Duration
 	ReadTimeout  time.Duration
@@ -21,52 +52,322 @@ type Config struct {
 	PingTimeout  time.Duration
 	OpTimeout    time.Duration
 	DefaultTTL   time.Duration
+	// ReconnectInterval is how often Run checks the server, both to
+	// leave degraded mode and to notice an outage while idle. Defaults to 5s.
+	ReconnectInterval time.Duration
+
+	// Breaker guards commands; its Name defaults to "redis".
+	Breaker breaker.Config
+	// Retry resends commands that fail with a Retryable error. Its
+	// Retryable defaults to the package's.
+	Retry retry.Policy
+
+	Logger *slog.Logger
+}
+
+// ErrUnavailable is returned without contacting the server while the client
+// is in degraded mode. Callers treat Redis as an optional cache and should
+// carry on without it.
+var ErrUnavailable = apperr.New(apperr.Unavailable, "redis: unavailable (degraded mode)")
+
+// universal boxes the client so it can be swapped atomically whatever
+// its concrete type.
+type universal struct {
+	redis.UniversalClient
 }
 
 type Client struct {
-	rc      *redis.Client
+	// rc is swapped by UpdatePassword; always read it through client().
+	rc      atomic.Pointer[universal]
 	metrics *metrics.Registry
 	cfg     Config
+	log     *slog.Logger
+	breaker *breaker.Breaker
+
+	rotateMu sync.Mutex
+
+	// up is false while Redis is unreachable; commands fail fast with
+	// ErrUnavailable until Run sees a successful PING.
+	up      atomic.Bool
+	recheck chan struct{}
 }
 
 func NewRedisClient(cfg Config) (*Client, error) {
+	if cfg.Mode == "" {
+		cfg.Mode = ModeStandalone
+	}
+	switch cfg.Mode {
+	case ModeStandalone:
+	case ModeSentinel:
+		if cfg.MasterName == "" || len(cfg.Addrs) == 0 {
+			return nil, errors.New("redis: sentinel mode needs a master name and sentinel addresses")
+		}
+	case ModeCluster:
+		if len(cfg.Addrs) == 0 {
+			return nil, errors.New("redis: cluster mode needs seed addresses")
+		}
+		if cfg.DB != 0 {
+			return nil, errors.New("redis: cluster mode only supports DB 0")
+		}
+	default:
+		return nil, fmt.Errorf("redis: unknown mode %q", cfg.Mode)
+	}
 	if cfg.PingTimeout == 0 {
 		cfg.PingTimeout = 3 * time.Second
 	}
 	if cfg.OpTimeout == 0 {
 		cfg.OpTimeout = 2 * time.Second
 	}
+	if cfg.ReconnectInterval == 0 {
+		cfg.ReconnectInterval = 5 * time.Second
+	}
+	if cfg.Breaker.Name == "" {
+		cfg.Breaker.Name = "redis"
+	}
+	if cfg.Breaker.IsFailure == nil {
+		cfg.Breaker.IsFailure = func(err error) bool {
+			return err != redis.Nil && breaker.DefaultIsFailure(err)
+		}
+	}
+	if cfg.Breaker.Logger == nil {
+		cfg.Breaker.Logger = cfg.Logger
+	}
+	if cfg.Retry.Retryable == nil {
+		cfg.Retry.Retryable = Retryable
+	}
 
-	log.Printf("[REDIS] Creating client for address %s", cfg.Addr)
+	logger := logging.Component(cfg.Logger, "redis")
+	if cfg.Mode == ModeStandalone {
+		logger.Info("Creating client", "mode", cfg.Mode, "addr", cfg.Addr, "tls", cfg.TLS != nil)
+	} else {
+		logger.Info("Creating client", "mode", cfg.Mode, "addrs", cfg.Addrs, "master", cfg.MasterName, "tls", cfg.TLS != nil)
+	}
 
-	rc := redis.NewClient(&redis.Options{
-		Addr:         cfg.Addr,
-		Password:     cfg.Password,
-		DB:           cfg.DB,
-		DialTimeout:  cfg.DialTimeout,
-		ReadTimeout:  cfg.ReadTimeout,
-		WriteTimeout: cfg.WriteTimeout,
-	})
+	c := &Client{
+		cfg:     cfg,
+		log:     logger,
+		breaker: breaker.New(cfg.Breaker),
+		recheck: make(chan struct{}, 1),
+	}
+	rc := newClient(cfg)
+	c.rc.Store(rc)
 
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.PingTimeout)
-	defer cancel()
+	// Postgres is the source of truth, so an unreachable Redis only costs
+	// us the cache: start degraded and let Run reconnect.
+	if err := ping(rc, cfg.PingTimeout); err != nil {
+		logger.Warn("Redis unavailable, starting in degraded mode", "error", err)
+		return c, nil
+	}
+	c.up.Store(true)
+	return c, nil
+}
 
+// newClient builds the go-redis client for cfg.Mode. Set, Get and the
+// rest of Client work the same against any of them.
+func newClient(cfg Config) *universal {
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.Addrs,
+		MasterName:       cfg.MasterName,
+		SentinelPassword: cfg.SentinelPassword,
+		Password:         cfg.Password,
+		TLSConfig:        cfg.TLS,
+		DB:               cfg.DB,
+		DialTimeout:      cfg.DialTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+		// Retries are done by Client with its own classification and
+		// metrics; the library's would multiply them.
+		MaxRetries: -1,
+	}
+	switch cfg.Mode {
+	case ModeSentinel:
+		return &universal{redis.NewFailoverClient(opts.Failover())}
+	case ModeCluster:
+		return &universal{redis.NewClusterClient(opts.Cluster())}
+	}
+	opts.Addrs = []string{cfg.Addr}
+	return &universal{redis.NewClient(opts.Simple())}
+}
+
+func ping(rc redis.UniversalClient, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 	if err := rc.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("redis ping: %w", err)
+	}
+	return nil
+}
+
+// connect creates a client for cfg and verifies it with PING.
+func connect(cfg Config) (*universal, error) {
+	rc := newClient(cfg)
+	if err := ping(rc, cfg.PingTimeout); err != nil {
 		_ = rc.Close()
-		return nil, fmt.Errorf("redis ping: %w", err)
+		return nil, err
+	}
+	return rc, nil
+}
+
+func (c *Client) client() redis.UniversalClient {
+	return c.rc.Load().UniversalClient
+}
+
+// Breaker returns the circuit breaker guarding commands.
+func (c *Client) Breaker() *breaker.Breaker {
+	return c.breaker
+}
+
+// Available reports whether the client is connected, i.e. not in degraded
+// mode.
+func (c *Client) Available() bool {
+	return c.up.Load()
+}
+
+// Run keeps the connection state current until ctx is cancelled: it PINGs
+// every ReconnectInterval, and immediately after a command fails with a
+// connection error, switching in and out of degraded mode as the result
+// changes.
+func (c *Client) Run(ctx context.Context) {
+	c.setGauge()
+	ticker := time.NewTicker(c.cfg.ReconnectInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.recheck:
+		}
+		if err := ping(c.client(), c.cfg.PingTimeout); err != nil {
+			if c.up.Load() {
+				c.markDown(err)
+			} else {
+				c.inc("redis_reconnect_total", map[string]string{"status": "error"})
+				c.log.Debug("Reconnect attempt failed", "error", err)
+			}
+			continue
+		}
+		if !c.up.Load() {
+			c.inc("redis_reconnect_total", map[string]string{"status": "success"})
+			c.markUp()
+		}
+	}
+}
+
+func (c *Client) markDown(err error) {
+	if !c.up.CompareAndSwap(true, false) {
+		return
+	}
+	c.log.Warn("Redis unavailable, entering degraded mode", "error", err)
+	c.setGauge()
+	select {
+	case c.recheck <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) markUp() {
+	if !c.up.CompareAndSwap(false, true) {
+		return
+	}
+	c.log.Info("Redis reachable again, leaving degraded mode")
+	c.setGauge()
+}
+
+func (c *Client) setGauge() {
+	if c.metrics == nil {
+		return
+	}
+	v := 0.0
+	if !c.up.Load() {
+		v = 1
+	}
+	c.metrics.SetGauge("redis_degraded", v, nil)
+}
+
+// connectionError reports whether err means the server could not be
+// reached, as opposed to a command-level error or a caller's cancellation.
+// The context errors are checked first: DeadlineExceeded is a net.Error.
+func connectionError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// UpdatePassword rebuilds the connection pool with a rotated password. The
+// new client must answer PING before it replaces the old one; the old one
+// is closed after in-flight commands have had OpTimeout to finish. While
+// degraded the new client is swapped in unverified and Run checks it.
+func (c *Client) UpdatePassword(password string) error {
+	c.rotateMu.Lock()
+	defer c.rotateMu.Unlock()
+
+	cfg := c.cfg
+	cfg.Password = password
+	if !c.up.Load() {
+		old := c.rc.Swap(newClient(cfg))
+		_ = old.Close()
+		c.log.Info("Rotated credentials while degraded; they will be verified on reconnect")
+		c.inc("redis_pool_rebuild_total", map[string]string{"status": "deferred"})
+		return nil
 	}
+	rc, err := connect(cfg)
+	if err != nil {
+		c.inc("redis_pool_rebuild_total", map[string]string{"status": "error"})
+		return err
+	}
+	old := c.rc.Swap(rc)
+	c.log.Info("Connection pool rebuilt with rotated credentials")
+	c.inc("redis_pool_rebuild_total", map[string]string{"status": "success"})
+
+	time.AfterFunc(2*cfg.OpTimeout, func() {
+		if err := old.Close(); err != nil {
+			c.log.Warn("Failed to close previous client", "error", err)
+		}
+	})
+	return nil
+}
+
+// Ping sends PING on the current client.
+func (c *Client) Ping(ctx context.Context) error {
+	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.PingTimeout)
+	defer cancel()
+	return c.client().Ping(ctx).Err()
+}
 
-	return &Client{
-		rc:  rc,
-		cfg: cfg,
-	}, nil
+func (c *Client) inc(name string, labels map[string]string) {
+	if c.metrics == nil {
+		return
+	}
+	c.metrics.IncrementCounter(name, labels)
 }
 
 func (c *Client) SetMetricsRegistry(reg *metrics.Registry) {
 	c.metrics = reg
+	c.breaker.SetMetricsRegistry(reg)
+	c.setGauge()
+}
+
+// skip reports whether cmd should be skipped because the client is
+// degraded.
+func (c *Client) skip(cmd string) bool {
+	if c.up.Load() {
+		return false
+	}
+	c.inc("redis_skipped_total", map[string]string{"cmd": cmd})
+	return true
 }
 
 func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if c.skip("SET") {
+		return ErrUnavailable
+	}
+	if err := c.breaker.Allow(); err != nil {
+		return translate("redis.SET", err)
+	}
+	ctx, span := startSpan(ctx, "SET", key)
 	start := time.Now()
 	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.OpTimeout)
 	defer cancel()
@@ -75,30 +376,124 @@ func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration)
 		ttl = c.cfg.DefaultTTL
 	}
 
-	err := c.rc.Set(ctx, key, value, ttl).Err()
-	c.observeSet(err, time.Since(start))
-	return err
+	attempts, err := c.cfg.Retry.Do(ctx, func(ctx context.Context) error {
+		return c.client().Set(ctx, key, value, ttl).Err()
+	})
+	c.retried(ctx, "SET", attempts, err)
+	c.observeSet(ctx, key, err, time.Since(start))
+	tracing.End(span, err)
+	return translate("redis.SET", err)
 }
 
+// Get returns the value at key. A miss is an error matching both
+// apperr.NotFound and redis.Nil.
 func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	if c.skip("GET") {
+		return "", ErrUnavailable
+	}
+	if err := c.breaker.Allow(); err != nil {
+		return "", translate("redis.GET", err)
+	}
+	ctx, span := startSpan(ctx, "GET", key)
 	start := time.Now()
 	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.OpTimeout)
 	defer cancel()
 
-	val, err := c.rc.Get(ctx, key).Result()
-	c.observeGet(err, time.Since(start))
-	return val, err
+	var val string
+	attempts, err := c.cfg.Retry.Do(ctx, func(ctx context.Context) error {
+		var err error
+		val, err = c.client().Get(ctx, key).Result()
+		return err
+	})
+	c.retried(ctx, "GET", attempts, err)
+	c.observeGet(ctx, key, err, time.Since(start))
+	if err == redis.Nil {
+		span.SetAttributes(attribute.Bool("cache.hit", false))
+		tracing.End(span, nil)
+	} else {
+		span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+		tracing.End(span, err)
+	}
+	return val, translate("redis.GET", err)
+}
+
+// Del removes key; removing a missing key is not an error.
+func (c *Client) Del(ctx context.Context, key string) error {
+	if c.skip("DEL") {
+		return ErrUnavailable
+	}
+	if err := c.breaker.Allow(); err != nil {
+		return translate("redis.DEL", err)
+	}
+	ctx, span := startSpan(ctx, "DEL", key)
+	ctx, cancel := withTimeoutIfNone(ctx, c.cfg.OpTimeout)
+	defer cancel()
+
+	attempts, err := c.cfg.Retry.Do(ctx, func(ctx context.Context) error {
+		return c.client().Del(ctx, key).Err()
+	})
+	c.retried(ctx, "DEL", attempts, err)
+	c.breaker.Record(err)
+	if connectionError(err) {
+		c.markDown(err)
+	}
+	status := "success"
+	if err != nil {
+		status = "error"
+		c.log.WarnContext(ctx, "DEL failed", "key", key, "error", err)
+	}
+	c.inc("redis_del_total", map[string]string{"status": status})
+	tracing.End(span, err)
+	return translate("redis.DEL", err)
 }
 
 func (c *Client) Close() error {
-	if err := c.rc.Close(); err != nil {
-		log.Printf("[REDIS] ERROR closing client: %v", err)
+	if err := c.client().Close(); err != nil {
+		c.log.Error("Failed to close client", "error", err)
 		return err
 	}
 	return nil
 }
 
-func (c *Client) observeSet(err error, d time.Duration) {
+// retried records the retries spent on cmd, if any.
+func (c *Client) retried(ctx context.Context, cmd string, attempts int, err error) {
+	if attempts <= 1 {
+		return
+	}
+	if err != nil && err != redis.Nil {
+		c.log.WarnContext(ctx, "Command failed after retries", "cmd", cmd, "attempts", attempts, "error", err)
+	} else {
+		c.log.InfoContext(ctx, "Command succeeded after retries", "cmd", cmd, "attempts", attempts)
+	}
+	if c.metrics == nil {
+		return
+	}
+	outcome := "recovered"
+	if err != nil && err != redis.Nil {
+		outcome = "failed"
+	}
+	c.metrics.AddCounter("redis_retries_total", float64(attempts-1), map[string]string{"cmd": cmd})
+	c.metrics.IncrementCounter("redis_retried_operations_total", map[string]string{"cmd": cmd, "outcome": outcome})
+}
+
+func startSpan(ctx context.Context, cmd, key string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "redis."+cmd, trace.SpanKindClient,
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation", cmd),
+		attribute.String("db.redis.key", key),
+	)
+}
+
+func (c *Client) observeSet(ctx context.Context, key string, err error, d time.Duration) {
+	c.breaker.Record(err)
+	if connectionError(err) {
+		c.markDown(err)
+	}
+	if err != nil {
+		c.log.WarnContext(ctx, "SET failed", "key", key, "error", err, "duration_ms", d.Milliseconds())
+	} else {
+		c.log.DebugContext(ctx, "SET completed", "key", key, "duration_ms", d.Milliseconds())
+	}
 	if c.metrics == nil {
 		return
 	}
@@ -116,7 +511,16 @@ func (c *Client) observeSet(err error, d time.Duration) {
 	})
 }
 
-func (c *Client) observeGet(err error, d time.Duration) {
+func (c *Client) observeGet(ctx context.Context, key string, err error, d time.Duration) {
+	c.breaker.Record(err)
+	if connectionError(err) {
+		c.markDown(err)
+	}
+	if err != nil && err != redis.Nil {
+		c.log.WarnContext(ctx, "GET failed", "key", key, "error", err, "duration_ms", d.Milliseconds())
+	} else {
+		c.log.DebugContext(ctx, "GET completed", "key", key, "hit", err == nil, "duration_ms", d.Milliseconds())
+	}
 	if c.metrics == nil {
 		return
 	}
@@ -0,0 +1,76 @@
+// Package retry runs operations again after transient failures, with
+// jittered exponential backoff bounded by the caller's context.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+type Policy struct {
+	// MaxAttempts includes the first call; 1 disables retries. Defaults to 3.
+	MaxAttempts int
+	// BaseDelay is the backoff cap before the first retry, doubled for each
+	// later one up to MaxDelay. Defaults to 50ms and 1s.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Retryable reports whether an error is worth another attempt. Without
+	// it nothing is retried.
+	Retryable func(error) bool
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 50 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = time.Second
+	}
+	if p.MaxDelay < p.BaseDelay {
+		p.MaxDelay = p.BaseDelay
+	}
+	return p
+}
+
+// Do calls fn until it succeeds, returns an error Retryable rejects, or
+// MaxAttempts is reached, and returns the last error with the number of
+// attempts made. It gives up early rather than sleep past ctx's deadline.
+func (p Policy) Do(ctx context.Context, fn func(ctx context.Context) error) (attempts int, err error) {
+	p = p.withDefaults()
+	for attempts = 1; ; attempts++ {
+		err = fn(ctx)
+		if err == nil || attempts >= p.MaxAttempts || p.Retryable == nil || !p.Retryable(err) {
+			return attempts, err
+		}
+		wait := p.Backoff(attempts)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			return attempts, err
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return attempts, err
+		case <-t.C:
+		}
+	}
+}
+
+// Backoff returns the delay before retry n (1-based): a uniformly random
+// duration up to min(MaxDelay, BaseDelay*2^(n-1)), so that clients failing
+// together do not retry together.
+func (p Policy) Backoff(n int) time.Duration {
+	p = p.withDefaults()
+	ceiling := p.BaseDelay
+	for i := 1; i < n && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
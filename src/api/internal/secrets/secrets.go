@@ -0,0 +1,119 @@
+// Package secrets reads credentials mounted as files (Docker and
+// Kubernetes secrets) and watches them for rotation.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"api/internal/logging"
+	"api/internal/metrics"
+)
+
+// ReadFile returns the file's content without the trailing newline most
+// secret tooling appends.
+func ReadFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret %s: %w", path, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+type watch struct {
+	name     string
+	path     string
+	value    string
+	onChange func(ctx context.Context, value string) error
+}
+
+// Watcher re-reads secret files on an interval and calls the registered
+// callback when a file's content changes. A failed callback is retried on
+// the next tick.
+type Watcher struct {
+	interval time.Duration
+	metrics  *metrics.Registry
+	log      *slog.Logger
+
+	mu      sync.Mutex
+	watches []*watch
+}
+
+func NewWatcher(interval time.Duration, logger *slog.Logger) *Watcher {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &Watcher{interval: interval, log: logging.Component(logger, "secrets")}
+}
+
+func (w *Watcher) SetMetricsRegistry(reg *metrics.Registry) {
+	w.metrics = reg
+}
+
+// Watch registers path under name (used in logs and metrics). current is
+// the value already in use, so the first tick does not count as a rotation.
+func (w *Watcher) Watch(name, path, current string, onChange func(ctx context.Context, value string) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watches = append(w.watches, &watch{name: name, path: path, value: current, onChange: onChange})
+}
+
+func (w *Watcher) Run(ctx context.Context) {
+	w.mu.Lock()
+	n := len(w.watches)
+	w.mu.Unlock()
+	if n == 0 {
+		return
+	}
+	w.log.Info("Watching secret files", "count", n, "interval", w.interval)
+
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			w.check(ctx)
+		}
+	}
+}
+
+func (w *Watcher) check(ctx context.Context) {
+	w.mu.Lock()
+	watches := append([]*watch(nil), w.watches...)
+	w.mu.Unlock()
+
+	for _, s := range watches {
+		value, err := ReadFile(s.path)
+		if err != nil {
+			// Mid-rotation the file can briefly be missing; keep the old value.
+			w.log.Warn("Failed to re-read secret", "secret", s.name, "error", err)
+			w.inc(s.name, "read_error")
+			continue
+		}
+		if value == s.value || value == "" {
+			continue
+		}
+		w.log.Info("Secret rotated, applying", "secret", s.name)
+		if err := s.onChange(ctx, value); err != nil {
+			w.log.Error("Failed to apply rotated secret", "secret", s.name, "error", err)
+			w.inc(s.name, "apply_error")
+			continue
+		}
+		s.value = value
+		w.inc(s.name, "rotated")
+	}
+}
+
+func (w *Watcher) inc(name, status string) {
+	if w.metrics == nil {
+		return
+	}
+	w.metrics.IncrementCounter("secrets_rotation_total", map[string]string{"secret": name, "status": status})
+}
@@ -0,0 +1,246 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+	// certPEM and keyPEM are the files the pair was written to.
+	certPEM string
+	keyPEM  string
+}
+
+// issue creates a certificate for cn signed by parent, or self-signed when
+// parent is nil, and writes it and its key to dir.
+func issue(t *testing.T, dir, cn string, parent *keyPair, isCA bool, usage x509.ExtKeyUsage) *keyPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		tmpl.DNSNames = []string{cn}
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kp := &keyPair{
+		cert:    cert,
+		key:     key,
+		tls:     tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		certPEM: filepath.Join(dir, cn+".crt"),
+		keyPEM:  filepath.Join(dir, cn+".key"),
+	}
+	writePEM(t, kp.certPEM, "CERTIFICATE", der)
+	writePEM(t, kp.keyPEM, "EC PRIVATE KEY", keyDER)
+	return kp
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects a client built from cfg to a server using server, and
+// returns the first error either side saw.
+func handshake(t *testing.T, cfg Config, server *tls.Config) error {
+	t.Helper()
+	tc, err := cfg.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	cConn, sConn := net.Pipe()
+	defer cConn.Close()
+	defer sConn.Close()
+	_ = cConn.SetDeadline(time.Now().Add(5 * time.Second))
+	_ = sConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	srv := tls.Server(sConn, server)
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- srv.Handshake()
+		// Unblock a client still waiting on the server's next flight.
+		sConn.Close()
+	}()
+	err = tls.Client(cConn, tc).Handshake()
+	cConn.Close()
+	if sErr := <-srvErr; err == nil && sErr != nil {
+		// With TLS 1.3 the client finishes before the server has checked
+		// its certificate, so a rejection only shows up server side.
+		err = sErr
+	}
+	return err
+}
+
+func TestHandshake(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, dir, "test-ca", nil, true, 0)
+	otherCA := issue(t, dir, "other-ca", nil, true, 0)
+	leaf := issue(t, dir, "db.internal", ca, false, x509.ExtKeyUsageServerAuth)
+	client := issue(t, dir, "worker", ca, false, x509.ExtKeyUsageClientAuth)
+
+	server := &tls.Config{Certificates: []tls.Certificate{leaf.tls}}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	mtlsServer := &tls.Config{
+		Certificates: []tls.Certificate{leaf.tls},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		server  *tls.Config
+		wantErr bool
+	}{
+		{
+			name:   "verify-full",
+			cfg:    Config{CAFile: ca.certPEM, ServerName: "db.internal", Verify: VerifyFull},
+			server: server,
+		},
+		{
+			name:   "default mode is verify-full",
+			cfg:    Config{CAFile: ca.certPEM, ServerName: "db.internal"},
+			server: server,
+		},
+		{
+			name:    "verify-full rejects name mismatch",
+			cfg:     Config{CAFile: ca.certPEM, ServerName: "10.0.0.5", Verify: VerifyFull},
+			server:  server,
+			wantErr: true,
+		},
+		{
+			name:    "verify-full rejects unknown CA",
+			cfg:     Config{CAFile: otherCA.certPEM, ServerName: "db.internal", Verify: VerifyFull},
+			server:  server,
+			wantErr: true,
+		},
+		{
+			name:   "verify-ca accepts name mismatch",
+			cfg:    Config{CAFile: ca.certPEM, ServerName: "10.0.0.5", Verify: VerifyCA},
+			server: server,
+		},
+		{
+			name:    "verify-ca rejects unknown CA",
+			cfg:     Config{CAFile: otherCA.certPEM, ServerName: "db.internal", Verify: VerifyCA},
+			server:  server,
+			wantErr: true,
+		},
+		{
+			name:   "none accepts unknown CA and name",
+			cfg:    Config{CAFile: otherCA.certPEM, ServerName: "10.0.0.5", Verify: VerifyNone},
+			server: server,
+		},
+		{
+			name: "mutual TLS",
+			cfg: Config{
+				CAFile: ca.certPEM, ServerName: "db.internal", Verify: VerifyFull,
+				CertFile: client.certPEM, KeyFile: client.keyPEM,
+			},
+			server: mtlsServer,
+		},
+		{
+			name:    "mutual TLS without client certificate",
+			cfg:     Config{CAFile: ca.certPEM, ServerName: "db.internal", Verify: VerifyFull},
+			server:  mtlsServer,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := handshake(t, tt.cfg, tt.server)
+			if tt.wantErr && err == nil {
+				t.Fatal("handshake succeeded, want an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("handshake: %v", err)
+			}
+		})
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, dir, "test-ca", nil, true, 0)
+	client := issue(t, dir, "worker", ca, false, x509.ExtKeyUsageClientAuth)
+	other := issue(t, dir, "other", ca, false, x509.ExtKeyUsageClientAuth)
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"cert without key", Config{CertFile: client.certPEM}, "must be set together"},
+		{"key without cert", Config{KeyFile: client.keyPEM}, "must be set together"},
+		{"mismatched pair", Config{CertFile: client.certPEM, KeyFile: other.keyPEM}, "load client certificate"},
+		{"missing cert file", Config{CertFile: filepath.Join(dir, "nope.crt"), KeyFile: client.keyPEM}, "load client certificate"},
+		{"missing CA file", Config{CAFile: filepath.Join(dir, "nope.pem")}, "read CA file"},
+		{"CA file without certificates", Config{CAFile: empty}, "no certificates found"},
+		{"unknown verify mode", Config{Verify: "prefer"}, "unknown verify mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.cfg.Build()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Build() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := (Config{CertFile: client.certPEM, KeyFile: client.keyPEM}).Build(); err != nil {
+		t.Fatalf("Build() with a matching pair: %v", err)
+	}
+}
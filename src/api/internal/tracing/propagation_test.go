@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestAMQPRoundTrip(t *testing.T) {
+	old := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(old) })
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	headers := InjectAMQP(ctx, nil)
+	if _, ok := headers["traceparent"].(string); !ok {
+		t.Fatalf("InjectAMQP headers = %v, want a traceparent", headers)
+	}
+
+	got := trace.SpanContextFromContext(ExtractAMQP(context.Background(), headers))
+	if !got.IsRemote() || got.TraceID() != sc.TraceID() || got.SpanID() != sc.SpanID() || !got.IsSampled() {
+		t.Errorf("extracted %+v, want the injected span context %+v", got, sc)
+	}
+
+	// Existing headers are kept alongside the trace context.
+	headers = InjectAMQP(ctx, amqp.Table{"x-source": "test"})
+	if headers["x-source"] != "test" || headers["traceparent"] == nil {
+		t.Errorf("InjectAMQP headers = %v, want both the original and traceparent", headers)
+	}
+}
+
+func TestExtractAMQPWithoutHeaders(t *testing.T) {
+	ctx := ExtractAMQP(context.Background(), nil)
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		t.Error("ExtractAMQP(nil) produced a span context")
+	}
+}
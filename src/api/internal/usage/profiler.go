@@ -0,0 +1,345 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"api/internal/logging"
+	"api/internal/metrics"
+)
+
+type ProfilerConfig struct {
+	Dir string
+	// Interval between scheduled CPU+heap captures.
+	Interval time.Duration
+	// CPUDuration is how long each CPU profile samples for.
+	CPUDuration time.Duration
+	// MaxBytes caps the total size of Dir; oldest profiles are removed first.
+	MaxBytes int64
+
+	// CPUThreshold is the fraction (0-1) of GOMAXPROCS the process may use
+	// before a triggered CPU profile is taken. Zero disables the trigger.
+	CPUThreshold float64
+	// GoroutineThreshold triggers a goroutine profile. Zero disables it.
+	GoroutineThreshold int
+	CheckInterval      time.Duration
+	// TriggerCooldown is the minimum time between two triggered captures.
+	TriggerCooldown time.Duration
+
+	Logger *slog.Logger
+}
+
+// ProfileInfo describes a stored profile.
+type ProfileInfo struct {
+	Name    string    `json:"name"`
+	Kind    string    `json:"kind"`
+	Reason  string    `json:"reason"`
+	Bytes   int64     `json:"bytes"`
+	Created time.Time `json:"created"`
+}
+
+// Profiler periodically captures short CPU and heap profiles into a
+// size-bounded directory and captures extra ones when CPU usage or the
+// goroutine count crosses a threshold.
+type Profiler struct {
+	cfg ProfilerConfig
+	reg *metrics.Registry
+	log *slog.Logger
+
+	mu          sync.Mutex // serialises captures and pruning
+	lastTrigger time.Time
+	lastCPU     time.Duration // user+system CPU time at the last check
+	lastWall    time.Time
+}
+
+func NewProfiler(cfg ProfilerConfig, reg *metrics.Registry) (*Profiler, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("profiler: dir is required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Minute
+	}
+	if cfg.CPUDuration <= 0 {
+		cfg.CPUDuration = 10 * time.Second
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 256 << 20
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = 5 * time.Second
+	}
+	if cfg.TriggerCooldown <= 0 {
+		cfg.TriggerCooldown = 5 * time.Minute
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("profiler: create dir: %w", err)
+	}
+	return &Profiler{
+		cfg: cfg,
+		reg: reg,
+		log: logging.Component(cfg.Logger, "usage"),
+	}, nil
+}
+
+func (p *Profiler) Run(ctx context.Context) {
+	scheduled := time.NewTicker(p.cfg.Interval)
+	defer scheduled.Stop()
+	checks := time.NewTicker(p.cfg.CheckInterval)
+	defer checks.Stop()
+
+	p.log.Info("Continuous profiler started",
+		"dir", p.cfg.Dir, "interval", p.cfg.Interval, "cpu_duration", p.cfg.CPUDuration, "max_bytes", p.cfg.MaxBytes)
+	p.cpuUtilisation() // prime the CPU counters
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.log.Info("Stopping continuous profiler due to context cancellation")
+			return
+		case <-scheduled.C:
+			p.capture(ctx, "scheduled", true, true)
+		case <-checks.C:
+			p.checkThresholds(ctx)
+		}
+	}
+}
+
+func (p *Profiler) checkThresholds(ctx context.Context) {
+	util := p.cpuUtilisation()
+	goroutines := runtime.NumGoroutine()
+	if p.reg != nil {
+		p.reg.SetGauge("profiler_cpu_utilisation_ratio", util, nil)
+	}
+
+	var reason string
+	switch {
+	case p.cfg.CPUThreshold > 0 && util >= p.cfg.CPUThreshold:
+		reason = "cpu"
+	case p.cfg.GoroutineThreshold > 0 && goroutines >= p.cfg.GoroutineThreshold:
+		reason = "goroutines"
+	default:
+		return
+	}
+	if time.Since(p.lastTrigger) < p.cfg.TriggerCooldown {
+		return
+	}
+	p.lastTrigger = time.Now()
+	p.log.Warn("Profiling threshold crossed", "reason", reason, "cpu_utilisation", util, "goroutines", goroutines)
+	if reason == "cpu" {
+		p.capture(ctx, reason, true, false)
+	} else {
+		p.capture(ctx, reason, false, true)
+	}
+}
+
+// cpuUtilisation returns the process's user+system CPU time since the
+// previous call as a share of its capacity, GOMAXPROCS * wall time. It
+// reads getrusage rather than runtime/metrics, whose CPU classes are only
+// updated at GC and so lag behind a busy process that allocates little.
+func (p *Profiler) cpuUtilisation() float64 {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	now := time.Now()
+	cpu := time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	dCPU, dWall := cpu-p.lastCPU, now.Sub(p.lastWall)
+	first := p.lastWall.IsZero()
+	p.lastCPU, p.lastWall = cpu, now
+	if first || dWall <= 0 {
+		return 0
+	}
+	return float64(dCPU) / (float64(dWall) * float64(runtime.GOMAXPROCS(0)))
+}
+
+// capture takes the requested profiles and prunes the directory.
+// Goroutine-triggered captures store a goroutine profile in place of heap.
+func (p *Profiler) capture(ctx context.Context, reason string, cpu, mem bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cpu {
+		if err := p.captureCPU(ctx, reason); err != nil {
+			p.log.Error("Failed to capture CPU profile", "error", err)
+			p.inc("cpu", "error")
+		} else {
+			p.inc("cpu", reason)
+		}
+	}
+	if mem {
+		kind := "heap"
+		if reason == "goroutines" {
+			kind = "goroutine"
+		}
+		if _, _, err := writeProfile(p.cfg.Dir, kind, reason); err != nil {
+			p.log.Error("Failed to capture profile", "kind", kind, "error", err)
+			p.inc(kind, "error")
+		} else {
+			p.inc(kind, reason)
+		}
+	}
+	if err := p.prune(); err != nil {
+		p.log.Error("Failed to prune profiles", "error", err)
+	}
+}
+
+func (p *Profiler) captureCPU(ctx context.Context, reason string) error {
+	path := filepath.Join(p.cfg.Dir, profileName("cpu", reason))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		// Usually another CPU profile (e.g. /debug/pprof/profile) is running.
+		_ = f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	select {
+	case <-time.After(p.cfg.CPUDuration):
+	case <-ctx.Done():
+	}
+	pprof.StopCPUProfile()
+	return f.Close()
+}
+
+func (p *Profiler) inc(kind, reason string) {
+	if p.reg == nil {
+		return
+	}
+	p.reg.IncrementCounter("profiler_captures_total", map[string]string{"kind": kind, "reason": reason})
+}
+
+// prune removes the oldest profiles until the directory fits MaxBytes.
+func (p *Profiler) prune() error {
+	infos, err := p.List()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, in := range infos {
+		total += in.Bytes
+	}
+	// List is newest first; drop from the tail.
+	for i := len(infos) - 1; i >= 0 && total > p.cfg.MaxBytes; i-- {
+		if err := os.Remove(filepath.Join(p.cfg.Dir, infos[i].Name)); err != nil {
+			return err
+		}
+		total -= infos[i].Bytes
+	}
+	if p.reg != nil {
+		p.reg.SetGauge("profiler_storage_bytes", float64(total), nil)
+	}
+	return nil
+}
+
+// List returns stored profiles, newest first.
+func (p *Profiler) List() ([]ProfileInfo, error) {
+	entries, err := os.ReadDir(p.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ProfileInfo, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".pb.gz") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		kind, reason := parseProfileName(e.Name())
+		out = append(out, ProfileInfo{
+			Name:    e.Name(),
+			Kind:    kind,
+			Reason:  reason,
+			Bytes:   fi.Size(),
+			Created: fi.ModTime().UTC(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+	return out, nil
+}
+
+// Handler lists profiles at its root and serves /<name> as a download. It
+// expects to be mounted with http.StripPrefix.
+func (p *Profiler) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.Trim(r.URL.Path, "/")
+		if name == "" {
+			infos, err := p.List()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(infos)
+			return
+		}
+		if name != filepath.Base(name) || !strings.HasSuffix(name, ".pb.gz") {
+			http.Error(w, "invalid profile name", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		http.ServeFile(w, r, filepath.Join(p.cfg.Dir, name))
+	})
+}
+
+// WriteHeapProfile writes a heap profile into dir and returns its path and
+// size.
+func WriteHeapProfile(dir, reason string) (string, int64, error) {
+	return writeProfile(dir, "heap", reason)
+}
+
+func writeProfile(dir, kind, reason string) (string, int64, error) {
+	prof := pprof.Lookup(kind)
+	if prof == nil {
+		return "", 0, fmt.Errorf("unknown profile %q", kind)
+	}
+	path := filepath.Join(dir, profileName(kind, reason))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return "", 0, fmt.Errorf("create %s profile: %w", kind, err)
+	}
+	if err := prof.WriteTo(f, 0); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return "", 0, fmt.Errorf("write %s profile: %w", kind, err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return "", 0, err
+	}
+	if err := f.Close(); err != nil {
+		return "", 0, err
+	}
+	return path, st.Size(), nil
+}
+
+// profileName is <kind>-<reason>-<timestamp>.pb.gz.
+func profileName(kind, reason string) string {
+	return fmt.Sprintf("%s-%s-%s.pb.gz", kind, reason, time.Now().UTC().Format("20060102T150405.000000000Z"))
+}
+
+func parseProfileName(name string) (kind, reason string) {
+	parts := strings.SplitN(strings.TrimSuffix(name, ".pb.gz"), "-", 3)
+	if len(parts) < 3 {
+		return "unknown", "unknown"
+	}
+	return parts[0], parts[1]
+}
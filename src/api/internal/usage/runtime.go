@@ -0,0 +1,164 @@
+package usage
+
+import (
+	"math"
+	rtmetrics "runtime/metrics"
+	"strings"
+
+	"api/internal/metrics"
+)
+
+// latencyBuckets are the upper bounds, in seconds, runtime latency
+// histograms are folded into before export.
+var latencyBuckets = []float64{
+	1e-6, 5e-6, 1e-5, 5e-5, 1e-4, 5e-4, 1e-3, 5e-3, 1e-2, 5e-2, 0.1, 0.5, 1,
+}
+
+// runtimeMetric maps a runtime/metrics name to the exported series.
+type runtimeMetric struct {
+	runtime string
+	name    string
+	labels  map[string]string
+}
+
+var runtimeMetrics = []runtimeMetric{
+	{runtime: "/gc/pauses:seconds", name: "go_gc_pause_seconds"},
+	{runtime: "/sched/latencies:seconds", name: "go_sched_latency_seconds"},
+	{runtime: "/gc/heap/goal:bytes", name: "go_gc_heap_goal_bytes"},
+	{runtime: "/gc/heap/live:bytes", name: "go_gc_heap_live_bytes"},
+	{runtime: "/gc/heap/objects:objects", name: "go_gc_heap_live_objects"},
+	{runtime: "/gc/heap/allocs:bytes", name: "go_gc_heap_allocs_bytes_total"},
+	{runtime: "/gc/heap/allocs:objects", name: "go_gc_heap_allocs_objects_total"},
+	{runtime: "/gc/cycles/total:gc-cycles", name: "go_gc_cycles_total"},
+	{runtime: "/gc/gomemlimit:bytes", name: "go_gc_memory_limit_bytes"},
+	{runtime: "/sync/mutex/wait/total:seconds", name: "go_sync_mutex_wait_seconds_total"},
+	{runtime: "/sched/goroutines:goroutines", name: "go_goroutines"},
+	{runtime: "/sched/gomaxprocs:threads", name: "go_gomaxprocs"},
+	{runtime: "/memory/classes/total:bytes", name: "go_memory_total_bytes"},
+	{runtime: "/memory/classes/heap/objects:bytes", name: "go_memory_heap_bytes", labels: map[string]string{"class": "objects"}},
+	{runtime: "/memory/classes/heap/unused:bytes", name: "go_memory_heap_bytes", labels: map[string]string{"class": "unused"}},
+	{runtime: "/memory/classes/heap/free:bytes", name: "go_memory_heap_bytes", labels: map[string]string{"class": "free"}},
+	{runtime: "/memory/classes/heap/released:bytes", name: "go_memory_heap_bytes", labels: map[string]string{"class": "released"}},
+	{runtime: "/memory/classes/heap/stacks:bytes", name: "go_memory_heap_bytes", labels: map[string]string{"class": "stacks"}},
+}
+
+// RuntimeCollector samples runtime/metrics and publishes them into a
+// metrics.Registry. Unlike runtime.ReadMemStats, reading runtime/metrics
+// does not stop the world.
+type RuntimeCollector struct {
+	reg        *metrics.Registry
+	samples    []rtmetrics.Sample
+	exports    []runtimeMetric
+	cumulative []bool
+	index      map[string]int
+}
+
+func NewRuntimeCollector(reg *metrics.Registry) *RuntimeCollector {
+	supported := make(map[string]rtmetrics.Description)
+	for _, d := range rtmetrics.All() {
+		supported[d.Name] = d
+	}
+
+	c := &RuntimeCollector{reg: reg, index: make(map[string]int)}
+	add := func(m runtimeMetric) {
+		d, ok := supported[m.runtime]
+		if !ok {
+			return
+		}
+		c.index[m.runtime] = len(c.samples)
+		c.samples = append(c.samples, rtmetrics.Sample{Name: m.runtime})
+		c.exports = append(c.exports, m)
+		c.cumulative = append(c.cumulative, d.Cumulative)
+	}
+	for _, m := range runtimeMetrics {
+		add(m)
+	}
+	// CPU class breakdown, e.g. /cpu/classes/gc/mark/assist:cpu-seconds.
+	for _, d := range rtmetrics.All() {
+		if !strings.HasPrefix(d.Name, "/cpu/classes/") {
+			continue
+		}
+		class := strings.TrimSuffix(strings.TrimPrefix(d.Name, "/cpu/classes/"), ":cpu-seconds")
+		add(runtimeMetric{
+			runtime: d.Name,
+			name:    "go_cpu_classes_seconds_total",
+			labels:  map[string]string{"class": class},
+		})
+	}
+	return c
+}
+
+// Collect reads the current runtime metrics and updates the registry.
+func (c *RuntimeCollector) Collect() {
+	rtmetrics.Read(c.samples)
+	if c.reg == nil {
+		return
+	}
+	for i, s := range c.samples {
+		m := c.exports[i]
+		switch s.Value.Kind() {
+		case rtmetrics.KindUint64:
+			c.set(m, float64(s.Value.Uint64()), c.cumulative[i])
+		case rtmetrics.KindFloat64:
+			c.set(m, s.Value.Float64(), c.cumulative[i])
+		case rtmetrics.KindFloat64Histogram:
+			counts, count, sum := foldHistogram(s.Value.Float64Histogram(), latencyBuckets)
+			c.reg.SetHistogram(m.name, m.labels, latencyBuckets, counts, count, sum)
+		}
+	}
+}
+
+func (c *RuntimeCollector) set(m runtimeMetric, v float64, cumulative bool) {
+	if cumulative {
+		c.reg.SetCounter(m.name, v, m.labels)
+		return
+	}
+	c.reg.SetGauge(m.name, v, m.labels)
+}
+
+// value returns the last sampled value of a scalar runtime metric.
+func (c *RuntimeCollector) value(name string) (float64, bool) {
+	i, ok := c.index[name]
+	if !ok {
+		return 0, false
+	}
+	v := c.samples[i].Value
+	switch v.Kind() {
+	case rtmetrics.KindUint64:
+		return float64(v.Uint64()), true
+	case rtmetrics.KindFloat64:
+		return v.Float64(), true
+	}
+	return 0, false
+}
+
+// foldHistogram converts a runtime histogram into cumulative counts for the
+// given upper bounds. Runtime buckets straddling a bound are attributed to
+// the next bound. The sum is estimated from bucket midpoints since the
+// runtime does not track it.
+func foldHistogram(h *rtmetrics.Float64Histogram, bounds []float64) ([]uint64, uint64, float64) {
+	counts := make([]uint64, len(bounds))
+	var total uint64
+	var sum float64
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+		lo, hi := h.Buckets[i], h.Buckets[i+1]
+		total += n
+		switch {
+		case math.IsInf(lo, -1):
+			sum += hi * float64(n)
+		case math.IsInf(hi, 1):
+			sum += lo * float64(n)
+		default:
+			sum += (lo + hi) / 2 * float64(n)
+		}
+		for j, b := range bounds {
+			if hi <= b {
+				counts[j] += n
+			}
+		}
+	}
+	return counts, total, sum
+}
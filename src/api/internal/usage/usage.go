@@ -1,36 +1,64 @@
 package usage
 import (
 	"context"
-	"log"
-	"runtime"
+	"log/slog"
 	"time"
+	"api/internal/logging"
 	"api/internal/metrics"
 )
-func MonitorMemory(ctx context.Context, reg *metrics.Registry, interval time.Duration) {
+// MonitorMemory publishes the runtime collector's metrics plus the legacy
+// app_* series every interval. It samples runtime/metrics rather than calling
+// the stop-the-world runtime.ReadMemStats.
+func MonitorMemory(ctx context.Context, reg *metrics.Registry, interval time.Duration, logger *slog.Logger) {
+	MonitorMemoryAdjustable(ctx, reg, interval, nil, logger)
+}
+
+// MonitorMemoryAdjustable is MonitorMemory whose collection interval can be
+// changed while running by sending the new value on intervals.
+func MonitorMemoryAdjustable(ctx context.Context, reg *metrics.Registry, interval time.Duration, intervals <-chan time.Duration, logger *slog.Logger) {
+	logger = logging.Component(logger, "usage")
 	if interval <= 0 {
 		interval = 10 * time.Second
 	}
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
-	log.Printf("[USAGE] Starting runtime memory metrics monitor (interval: %v)", interval)
+	collector := NewRuntimeCollector(reg)
+	logger.Info("Starting runtime memory metrics monitor", "interval", interval)
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("[USAGE] Stopping memory metrics monitor due to context cancellation")
+			logger.Info("Stopping memory metrics monitor due to context cancellation")
 			return
+		case d := <-intervals:
+			if d > 0 && d != interval {
+				interval = d
+				ticker.Reset(interval)
+				logger.Info("Memory monitor interval changed", "interval", interval)
+			}
 		case <-ticker.C:
-			var m runtime.MemStats
-			runtime.ReadMemStats(&m)
-			numGoroutines := runtime.NumGoroutine()
+			collector.Collect()
 			if reg != nil {
-				reg.SetGauge("app_memory_usage_bytes", float64(m.Alloc), map[string]string{"type": "alloc"})
-				reg.SetGauge("app_memory_usage_bytes", float64(m.TotalAlloc), map[string]string{"type": "total_alloc"})
-				reg.SetGauge("app_memory_usage_bytes", float64(m.Sys), map[string]string{"type": "sys"})
-				reg.SetGauge("app_memory_usage_bytes", float64(m.HeapAlloc), map[string]string{"type": "heap_alloc"})
-				reg.SetGauge("app_memory_usage_bytes", float64(m.HeapInuse), map[string]string{"type": "heap_inuse"})
-				reg.SetGauge("app_gc_runs_total", float64(m.NumGC), map[string]string{})
-				reg.SetGauge("app_goroutines_total", float64(numGoroutines), map[string]string{})
+				publishLegacy(reg, collector)
 			}
 		}
 	}
 }
+
+// publishLegacy keeps the series dashboards were built on before the
+// runtime collector existed.
+func publishLegacy(reg *metrics.Registry, c *RuntimeCollector) {
+	heapObjects, _ := c.value("/memory/classes/heap/objects:bytes")
+	heapUnused, _ := c.value("/memory/classes/heap/unused:bytes")
+	totalAlloc, _ := c.value("/gc/heap/allocs:bytes")
+	sys, _ := c.value("/memory/classes/total:bytes")
+	gcCycles, _ := c.value("/gc/cycles/total:gc-cycles")
+	goroutines, _ := c.value("/sched/goroutines:goroutines")
+
+	reg.SetGauge("app_memory_usage_bytes", heapObjects, map[string]string{"type": "alloc"})
+	reg.SetGauge("app_memory_usage_bytes", totalAlloc, map[string]string{"type": "total_alloc"})
+	reg.SetGauge("app_memory_usage_bytes", sys, map[string]string{"type": "sys"})
+	reg.SetGauge("app_memory_usage_bytes", heapObjects, map[string]string{"type": "heap_alloc"})
+	reg.SetGauge("app_memory_usage_bytes", heapObjects+heapUnused, map[string]string{"type": "heap_inuse"})
+	reg.SetCounter("app_gc_runs_total", gcCycles, map[string]string{})
+	reg.SetGauge("app_goroutines_total", goroutines, map[string]string{})
+}
@@ -0,0 +1,236 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	rtmetrics "runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"api/internal/logging"
+	"api/internal/metrics"
+)
+
+// ErrMemoryPressure is returned by Watchdog.Admit while the process is
+// above its high watermark.
+var ErrMemoryPressure = errors.New("memory pressure: shedding load")
+
+type WatchdogConfig struct {
+	// SoftLimit is the memory budget in bytes. Zero detects it from
+	// GOMEMLIMIT or the cgroup memory limit.
+	SoftLimit int64
+	// HighWatermark and LowWatermark are fractions of SoftLimit at which
+	// load shedding starts and stops.
+	HighWatermark float64
+	LowWatermark  float64
+	// SetRuntimeLimit also applies SoftLimit via debug.SetMemoryLimit so the
+	// GC works harder before the watchdog has to shed load.
+	SetRuntimeLimit bool
+	Interval        time.Duration
+	// MinGCInterval bounds how often the watchdog forces a collection while
+	// usage stays above the high watermark.
+	MinGCInterval time.Duration
+
+	Logger *slog.Logger
+}
+
+// Watchdog compares the runtime's memory footprint against a soft limit and
+// notifies listeners when the process enters or leaves memory pressure.
+type Watchdog struct {
+	cfg     WatchdogConfig
+	reg     *metrics.Registry
+	log     *slog.Logger
+	samples []rtmetrics.Sample
+
+	mu        sync.RWMutex
+	pressure  bool
+	listeners []func(underPressure bool)
+	lastGC    time.Time
+}
+
+func NewWatchdog(cfg WatchdogConfig, reg *metrics.Registry) (*Watchdog, error) {
+	if cfg.SoftLimit <= 0 {
+		cfg.SoftLimit = detectMemoryLimit()
+	}
+	if cfg.SoftLimit <= 0 {
+		return nil, errors.New("watchdog: no soft memory limit configured or detected")
+	}
+	if cfg.HighWatermark == 0 {
+		cfg.HighWatermark = 0.90
+	}
+	if cfg.LowWatermark == 0 {
+		cfg.LowWatermark = 0.75
+	}
+	if cfg.LowWatermark >= cfg.HighWatermark {
+		return nil, errors.New("watchdog: low watermark must be below high watermark")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.MinGCInterval <= 0 {
+		cfg.MinGCInterval = 10 * time.Second
+	}
+	if cfg.SetRuntimeLimit {
+		debug.SetMemoryLimit(cfg.SoftLimit)
+	}
+
+	return &Watchdog{
+		cfg: cfg,
+		reg: reg,
+		log: logging.Component(cfg.Logger, "usage"),
+		samples: []rtmetrics.Sample{
+			{Name: "/memory/classes/total:bytes"},
+			{Name: "/memory/classes/heap/released:bytes"},
+		},
+	}, nil
+}
+
+// OnChange registers fn to be called on every pressure transition. It is
+// called from the watchdog goroutine.
+func (w *Watchdog) OnChange(fn func(underPressure bool)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners = append(w.listeners, fn)
+}
+
+func (w *Watchdog) UnderPressure() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.pressure
+}
+
+// Admit returns ErrMemoryPressure while load should be shed.
+func (w *Watchdog) Admit() error {
+	if w.UnderPressure() {
+		return ErrMemoryPressure
+	}
+	return nil
+}
+
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	w.log.Info("Memory watchdog started",
+		"soft_limit_bytes", w.cfg.SoftLimit,
+		"high_watermark", w.cfg.HighWatermark,
+		"low_watermark", w.cfg.LowWatermark,
+		"runtime_limit", w.cfg.SetRuntimeLimit,
+	)
+	w.setGauges(w.usage())
+	for {
+		select {
+		case <-ctx.Done():
+			w.log.Info("Stopping memory watchdog due to context cancellation")
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// usage is the memory the runtime holds from the OS, which is what
+// debug.SetMemoryLimit is measured against.
+func (w *Watchdog) usage() int64 {
+	rtmetrics.Read(w.samples)
+	return int64(w.samples[0].Value.Uint64() - w.samples[1].Value.Uint64())
+}
+
+func (w *Watchdog) check() {
+	used := w.usage()
+	high := int64(float64(w.cfg.SoftLimit) * w.cfg.HighWatermark)
+	low := int64(float64(w.cfg.SoftLimit) * w.cfg.LowWatermark)
+
+	w.mu.Lock()
+	was := w.pressure
+	now := was
+	switch {
+	case !was && used >= high:
+		now = true
+	case was && used <= low:
+		now = false
+	}
+	w.pressure = now
+	forceGC := now && time.Since(w.lastGC) >= w.cfg.MinGCInterval
+	if forceGC {
+		w.lastGC = time.Now()
+	}
+	listeners := append([]func(bool){}, w.listeners...)
+	w.mu.Unlock()
+
+	if forceGC {
+		runtime.GC()
+		used = w.usage()
+		w.inc("memory_watchdog_gc_total", nil)
+	}
+	w.setGauges(used)
+
+	if now == was {
+		return
+	}
+	if now {
+		w.log.Warn("Memory pressure, shedding load",
+			"usage_bytes", used, "high_bytes", high, "soft_limit_bytes", w.cfg.SoftLimit)
+		w.inc("memory_watchdog_transitions_total", map[string]string{"state": "pressure"})
+	} else {
+		w.log.Info("Memory pressure relieved, resuming", "usage_bytes", used, "low_bytes", low)
+		w.inc("memory_watchdog_transitions_total", map[string]string{"state": "normal"})
+	}
+	for _, fn := range listeners {
+		fn(now)
+	}
+}
+
+func (w *Watchdog) setGauges(used int64) {
+	if w.reg == nil {
+		return
+	}
+	state := 0.0
+	if w.UnderPressure() {
+		state = 1
+	}
+	w.reg.SetGauge("memory_watchdog_pressure", state, nil)
+	w.reg.SetGauge("memory_watchdog_usage_bytes", float64(used), nil)
+	w.reg.SetGauge("memory_watchdog_limit_bytes", float64(w.cfg.SoftLimit), nil)
+}
+
+func (w *Watchdog) inc(name string, labels map[string]string) {
+	if w.reg == nil {
+		return
+	}
+	w.reg.IncrementCounter(name, labels)
+}
+
+// detectMemoryLimit returns GOMEMLIMIT when set, otherwise the cgroup
+// (v2, then v1) memory limit, or 0 if neither is available.
+func detectMemoryLimit() int64 {
+	if l := debug.SetMemoryLimit(-1); l > 0 && l != math.MaxInt64 {
+		return l
+	}
+	for _, path := range []string{
+		"/sys/fs/cgroup/memory.max",
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+	} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		v := strings.TrimSpace(string(b))
+		if v == "max" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		// cgroup v1 reports a huge page-aligned number when unlimited.
+		if err != nil || n <= 0 || n >= math.MaxInt64/2 {
+			continue
+		}
+		return n
+	}
+	return 0
+}
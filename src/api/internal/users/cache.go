@@ -0,0 +1,129 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"api/internal/apperr"
+)
+
+// cacheEntry is what UsersManager stores under user:<id>. The Redis TTL
+// runs past FreshUntil by the stale window, so a stale entry can still be
+// served while it is refreshed.
+type cacheEntry struct {
+	User json.RawMessage `json:"user"`
+	// FreshUntil is when the entry expires, in Unix milliseconds.
+	FreshUntil int64 `json:"fresh_until"`
+	// Delta is how long producing the entry took, in milliseconds; the
+	// slower the load, the earlier a refresh starts.
+	Delta int64 `json:"delta_ms"`
+}
+
+func (e cacheEntry) freshUntil() time.Time {
+	return time.UnixMilli(e.FreshUntil)
+}
+
+// expiresEarly reports whether a read at now should refresh e before it
+// expires. This is probabilistic early expiration (XFetch): each reader
+// draws a head start of delta*beta*-ln(rand), so among many concurrent
+// readers of a hot key one refreshes it shortly before expiry instead of
+// all of them missing together just after.
+func (e cacheEntry) expiresEarly(now time.Time, beta float64) bool {
+	if beta <= 0 || e.Delta <= 0 {
+		return false
+	}
+	headStart := float64(e.Delta) * beta * -math.Log(1-uniform())
+	return now.Add(time.Duration(headStart * float64(time.Millisecond))).After(e.freshUntil())
+}
+
+// uniform draws expiresEarly's random number; tests fix it.
+var uniform = rand.Float64
+
+func cacheKey(userID string) string {
+	return "user:" + userID
+}
+
+// store caches data for userID. delta is how long producing it took.
+func (u *UsersManager) store(ctx context.Context, userID string, data []byte, delta time.Duration) error {
+	ttl := time.Duration(u.cacheTTL.Load())
+	entry, err := json.Marshal(cacheEntry{
+		User:       data,
+		FreshUntil: time.Now().Add(ttl).UnixMilli(),
+		Delta:      delta.Milliseconds(),
+	})
+	if err != nil {
+		return apperr.E(apperr.Internal, "marshal cache entry", err)
+	}
+	return u.redis.Set(ctx, cacheKey(userID), string(entry), ttl+time.Duration(u.staleTTL.Load()))
+}
+
+// cached reads userID's cache entry. result is hit, miss, skipped (no
+// Redis) or error; entry and usr are only meaningful on a hit.
+func (u *UsersManager) cached(ctx context.Context, userID string) (entry cacheEntry, usr User, result string) {
+	if u.redis == nil {
+		return entry, usr, "skipped"
+	}
+	raw, err := u.redis.Get(ctx, cacheKey(userID))
+	switch {
+	case errors.Is(err, apperr.NotFound):
+		return entry, usr, "miss"
+	case errors.Is(err, apperr.Unavailable):
+		return entry, usr, "skipped"
+	case err != nil:
+		return entry, usr, "error"
+	}
+	// Entries written before the envelope existed, or damaged ones, are
+	// treated as misses and overwritten by the reload.
+	if json.Unmarshal([]byte(raw), &entry) != nil || json.Unmarshal(entry.User, &usr) != nil {
+		return entry, usr, "miss"
+	}
+	return entry, usr, "hit"
+}
+
+// flight coalesces concurrent calls for the same key into one.
+type flight[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+type call[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+}
+
+// do runs fn once for all callers asking for key at the same time and
+// gives each the result; shared is true for the callers that joined an
+// existing call. fn runs in its own goroutine, so a caller whose ctx ends
+// returns ctx.Err() without cancelling it for the others.
+func (f *flight[T]) do(ctx context.Context, key string, fn func() (T, error)) (val T, shared bool, err error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]*call[T])
+	}
+	c, shared := f.calls[key]
+	if !shared {
+		c = &call[T]{done: make(chan struct{})}
+		f.calls[key] = c
+		go func() {
+			c.val, c.err = fn()
+			f.mu.Lock()
+			delete(f.calls, key)
+			f.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, shared, c.err
+	case <-ctx.Done():
+		return val, shared, ctx.Err()
+	}
+}
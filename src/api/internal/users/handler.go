@@ -0,0 +1,77 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"api/internal/apperr"
+)
+
+// Handler serves users under /users/:
+//
+//	GET    /users/{id}    fetch one user through the cache
+//	PUT    /users/{id}    replace an existing user's fields
+//	DELETE /users/{id}    remove a user
+type Handler struct {
+	users *UsersManager
+}
+
+func NewHandler(u *UsersManager) *Handler {
+	return &Handler{users: u}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/users/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		usr, err := h.users.GetUser(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, usr)
+	case http.MethodPut:
+		var body User
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&body); err != nil {
+			writeError(w, apperr.E(apperr.Validation, "users.Handler", err))
+			return
+		}
+		usr, err := h.users.UpdateUser(r.Context(), id, body.FirstName, body.LastName, body.Age, body.MaritalStatus)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, usr)
+	case http.MethodDelete:
+		if err := h.users.DeleteUser(r.Context(), id); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// maxBodyBytes bounds a PUT body; a user is a few hundred bytes.
+const maxBodyBytes = 64 << 10
+
+func writeError(w http.ResponseWriter, err error) {
+	status := apperr.HTTPStatus(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "30")
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error(), "code": apperr.Code(err)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,253 @@
+package users
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// localEntryOverhead approximates the bookkeeping per entry (list element,
+// map slot, User header) on top of the key and the user's JSON.
+const localEntryOverhead = 160
+
+// localCache is the in-process tier in front of Redis: an LRU bounded in
+// bytes whose entries also expire after their TTL.
+type localCache struct {
+	maxBytes int64
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List // front is most recently used
+	items map[string]*list.Element
+	bytes int64
+	// seq counts removals, so a load that started before one of its key
+	// does not put back what it removed: removed holds each key's last
+	// removal, and loads older than floor (the last purge, or the newest
+	// tombstone swept) are dropped whatever their key.
+	seq       uint64
+	removed   map[string]tombstone
+	floor     uint64
+	lastSweep time.Time
+
+	onEvict func(reason string)
+}
+
+type tombstone struct {
+	seq uint64
+	at  time.Time
+}
+
+type localEntry struct {
+	key     string
+	user    User
+	size    int64
+	expires time.Time
+}
+
+func newLocalCache(maxBytes int64, ttl time.Duration, onEvict func(reason string)) *localCache {
+	return &localCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		removed:  make(map[string]tombstone),
+		onEvict:  onEvict,
+	}
+}
+
+func (c *localCache) get(key string, now time.Time) (User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return User{}, false
+	}
+	e := el.Value.(*localEntry)
+	if !now.Before(e.expires) {
+		c.removeElement(el, "expired")
+		return User{}, false
+	}
+	c.ll.MoveToFront(el)
+	return e.user, true
+}
+
+// generation returns the value to pass to put for data read from now on.
+func (c *localCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seq
+}
+
+// put stores usr, whose JSON encoding is dataLen bytes, for at most ttl
+// (capped at the cache's own TTL). It does nothing if key was removed, or
+// the cache purged, since gen was taken.
+func (c *localCache) put(key string, usr User, dataLen int, ttl time.Duration, gen uint64, now time.Time) {
+	if ttl <= 0 || ttl > c.ttl {
+		ttl = c.ttl
+	}
+	size := int64(len(key)+dataLen) + localEntryOverhead
+	if size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen < c.floor {
+		return
+	}
+	if t, ok := c.removed[key]; ok && t.seq > gen {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el, "")
+	}
+	c.items[key] = c.ll.PushFront(&localEntry{key: key, user: usr, size: size, expires: now.Add(ttl)})
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.removeElement(c.ll.Back(), "size")
+	}
+}
+
+// remove drops key, reporting whether it was cached. Loads of other keys
+// already under way may still fill the cache.
+func (c *localCache) remove(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.sweep(now)
+	c.seq++
+	c.removed[key] = tombstone{seq: c.seq, at: now}
+	el, ok := c.items[key]
+	if ok {
+		c.removeElement(el, "invalidated")
+	}
+	return ok
+}
+
+// purge drops every entry.
+func (c *localCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	c.floor = c.seq
+	clear(c.removed)
+	for _, el := range c.items {
+		c.removeElement(el, "purged")
+	}
+}
+
+func (c *localCache) stats() (entries int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items), c.bytes
+}
+
+// sweep drops tombstones older than the TTL, at most once per TTL. A load
+// that old is refused by floor instead.
+func (c *localCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	for key, t := range c.removed {
+		if now.Sub(t.at) >= c.ttl {
+			delete(c.removed, key)
+			if t.seq > c.floor {
+				c.floor = t.seq
+			}
+		}
+	}
+}
+
+// removeElement drops el; a non-empty reason is reported to onEvict.
+func (c *localCache) removeElement(el *list.Element, reason string) {
+	e := c.ll.Remove(el).(*localEntry)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+	if reason != "" && c.onEvict != nil {
+		c.onEvict(reason)
+	}
+}
+
+// invalidateChannel carries the IDs of changed users between replicas.
+const invalidateChannel = "users:invalidate"
+
+// tierStats counts lookups in one cache tier for its hit ratio gauge.
+type tierStats struct {
+	hits    atomic.Int64
+	lookups atomic.Int64
+}
+
+// EnableLocalCache puts an in-process tier of at most maxBytes in front of
+// Redis, holding each user for at most ttl. Call it before the manager is
+// used, and run Run so that changes on other replicas evict entries here.
+func (u *UsersManager) EnableLocalCache(maxBytes int64, ttl time.Duration) {
+	if maxBytes <= 0 || ttl <= 0 {
+		return
+	}
+	u.local = newLocalCache(maxBytes, ttl, func(reason string) {
+		u.inc("users_local_cache_evictions_total", map[string]string{"reason": reason})
+	})
+}
+
+// Run applies invalidations published by other replicas until ctx is
+// cancelled. It returns at once if the local cache is disabled. While the
+// subscription is down invalidations are missed, so the local cache is
+// emptied whenever it is re-established.
+func (u *UsersManager) Run(ctx context.Context) {
+	if u.local == nil || u.redis == nil {
+		return
+	}
+	u.redis.Subscribe(ctx, invalidateChannel, func(userID string) {
+		u.local.remove(userID)
+		u.setLocalGauges()
+	}, func() {
+		u.local.purge()
+		u.setLocalGauges()
+	})
+}
+
+// InvalidateUser drops userID from the local cache here and on every other
+// replica. Publishing is best effort; the local TTL bounds how long a
+// replica that misses it serves the old copy.
+func (u *UsersManager) InvalidateUser(ctx context.Context, userID string) {
+	if u.local == nil {
+		return
+	}
+	u.local.remove(userID)
+	u.setLocalGauges()
+	if u.redis == nil {
+		return
+	}
+	status := "success"
+	if err := u.redis.Publish(ctx, invalidateChannel, userID); err != nil {
+		status = "error"
+	}
+	u.inc("users_invalidations_published_total", map[string]string{"status": status})
+}
+
+// tier records a lookup in the named cache tier.
+func (u *UsersManager) tier(name string, st *tierStats, hit bool) {
+	lookups := st.lookups.Add(1)
+	hits := st.hits.Load()
+	result := "miss"
+	if hit {
+		hits = st.hits.Add(1)
+		result = "hit"
+	}
+	if u.metrics == nil {
+		return
+	}
+	u.metrics.IncrementCounter("users_cache_tier_total", map[string]string{"tier": name, "result": result})
+	u.metrics.SetGauge("users_cache_hit_ratio", float64(hits)/float64(lookups), map[string]string{"tier": name})
+}
+
+func (u *UsersManager) setLocalGauges() {
+	if u.metrics == nil || u.local == nil {
+		return
+	}
+	entries, bytes := u.local.stats()
+	u.metrics.SetGauge("users_local_cache_entries", float64(entries), nil)
+	u.metrics.SetGauge("users_local_cache_bytes", float64(bytes), nil)
+}
@@ -0,0 +1,144 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"api/internal/pg_gateway"
+)
+
+func TestLocalCacheRemovalOnlyBlocksItsKey(t *testing.T) {
+	c := newLocalCache(1<<20, time.Minute, nil)
+	now := time.Now()
+	gen := c.generation()
+
+	c.remove("a")
+	c.put("a", User{UserID: "a"}, 10, 0, gen, now)
+	c.put("b", User{UserID: "b"}, 10, 0, gen, now)
+	if _, ok := c.get("a", now); ok {
+		t.Error("load that started before a's removal put it back")
+	}
+	if _, ok := c.get("b", now); !ok {
+		t.Error("removing a blocked a concurrent load of b")
+	}
+
+	// A load that starts after the removal may fill the cache again.
+	c.put("a", User{UserID: "a"}, 10, 0, c.generation(), now)
+	if _, ok := c.get("a", now); !ok {
+		t.Error("load that started after a's removal was dropped")
+	}
+}
+
+func TestLocalCachePurgeBlocksEveryKey(t *testing.T) {
+	c := newLocalCache(1<<20, time.Minute, nil)
+	now := time.Now()
+	gen := c.generation()
+	c.purge()
+	c.put("b", User{UserID: "b"}, 10, 0, gen, now)
+	if _, ok := c.get("b", now); ok {
+		t.Error("load that started before a purge filled the cache")
+	}
+}
+
+func TestLocalCacheSweepsTombstones(t *testing.T) {
+	c := newLocalCache(1<<20, time.Millisecond, nil)
+	old := c.generation()
+	c.remove("a")
+	time.Sleep(2 * time.Millisecond)
+	c.remove("b")
+	if _, ok := c.removed["a"]; ok {
+		t.Error("expired tombstone was kept")
+	}
+	// The sweep must not let a load older than the swept removal through.
+	now := time.Now()
+	c.put("a", User{UserID: "a"}, 10, time.Millisecond, old, now)
+	if _, ok := c.get("a", now); ok {
+		t.Error("load older than a swept removal filled the cache")
+	}
+}
+
+func TestCreateUserDoesNotInvalidate(t *testing.T) {
+	c := newFakeCache()
+	u := newUsersManager(c, &fakeStore{}, nil, time.Minute)
+	u.EnableLocalCache(1<<20, time.Minute)
+	if _, err := u.CreateUser(context.Background(), "Ada", "Lovelace", 36, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.publishes.Load(); n != 0 {
+		t.Errorf("CreateUser published %d invalidations, want 0", n)
+	}
+}
+
+// replicas returns two managers with local caches that share one Redis, as
+// two replicas would, with Run subscribed on both.
+func replicas(t *testing.T, st *fakeStore) (a, b *UsersManager, c *fakeCache) {
+	t.Helper()
+	c = newFakeCache()
+	c.bus = &fakeBus{}
+	a = newUsersManager(c, st, nil, time.Minute)
+	b = newUsersManager(c, st, nil, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	for _, u := range []*UsersManager{a, b} {
+		u.EnableLocalCache(1<<20, time.Minute)
+		go u.Run(ctx)
+	}
+	waitFor(t, "both subscriptions", func() bool { return c.bus.subscribers(invalidateChannel) == 2 })
+	return a, b, c
+}
+
+// warm reads userID through u so that its local cache holds it.
+func warm(t *testing.T, u *UsersManager, userID string) {
+	t.Helper()
+	if _, err := u.GetUser(context.Background(), userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := u.local.get(userID, time.Now()); !ok {
+		t.Fatalf("%s was not cached locally", userID)
+	}
+}
+
+func TestUpdateUserEvictsOtherReplicas(t *testing.T) {
+	st := &fakeStore{users: map[string]User{"u1": {UserID: "u1", FirstName: "Old", LastName: "L"}}}
+	a, b, _ := replicas(t, st)
+	warm(t, b, "u1")
+
+	if _, err := a.UpdateUser(context.Background(), "u1", "New", "L", 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.local.get("u1", time.Now()); ok {
+		t.Fatal("update on one replica left the old copy in the other's local cache")
+	}
+	usr, err := b.GetUser(context.Background(), "u1")
+	if err != nil || usr.FirstName != "New" {
+		t.Errorf("GetUser on the other replica = %+v, %v, want the update", usr, err)
+	}
+}
+
+func TestDeleteUserEvictsOtherReplicas(t *testing.T) {
+	st := &fakeStore{users: map[string]User{"u1": {UserID: "u1", FirstName: "Ada", LastName: "L"}}}
+	a, b, _ := replicas(t, st)
+	warm(t, b, "u1")
+
+	if err := a.DeleteUser(context.Background(), "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetUser(context.Background(), "u1"); !errors.Is(err, pg_gateway.ErrUserNotFound) {
+		t.Errorf("GetUser on the other replica after delete = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestCreateUserOverExistingRowInvalidates(t *testing.T) {
+	st := &fakeStore{upsertOnly: true}
+	_, _, c := replicas(t, st)
+	u := newUsersManager(c, st, nil, time.Minute)
+	u.EnableLocalCache(1<<20, time.Minute)
+	if _, err := u.CreateUser(context.Background(), "Ada", "Lovelace", 36, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.publishes.Load(); n != 1 {
+		t.Errorf("upsert over an existing row published %d invalidations, want 1", n)
+	}
+}
@@ -3,22 +3,64 @@ package users
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"api/internal/apperr"
+	"api/internal/correlation"
 	"api/internal/metrics"
 	"api/internal/pg_gateway"
 	"api/internal/redis_gateway"
+	"api/internal/tracing"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// store is the Postgres side of UsersManager, implemented by
+// *pg_gateway.Client.
+type store interface {
+	SaveUser(ctx context.Context, userID string, jsonData string) (created bool, err error)
+	UpdateUser(ctx context.Context, userID string, jsonData string) error
+	DeleteUser(ctx context.Context, userID string) error
+	GetUsers(ctx context.Context) ([]pg_gateway.StoredUser, error)
+	GetUser(ctx context.Context, userID string) (*pg_gateway.StoredUser, error)
+}
+
+// cache is the Redis side of UsersManager, implemented by
+// *redis_gateway.Client.
+type cache interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Del(ctx context.Context, key string) error
+	Publish(ctx context.Context, channel, payload string) error
+	Subscribe(ctx context.Context, channel string, handle func(payload string), onSubscribe func())
+}
+
 type UsersManager struct {
-	redis *redis_gateway.Client
-	pg    *pg_gateway.Client
+	// redis is nil when no Redis client was given.
+	redis cache
+	pg    store
 
 	metrics  *metrics.Registry
-	cacheTTL time.Duration
+	cacheTTL atomic.Int64 // time.Duration; adjustable via SetCacheTTL
+
+	// staleTTL and earlyBeta (float64 bits) tune GetUser; see
+	// SetStaleWhileRevalidate and SetEarlyExpiration.
+	staleTTL  atomic.Int64
+	earlyBeta atomic.Uint64
+	loads     flight[User]
+
+	// local is the optional in-process tier; see EnableLocalCache.
+	local      *localCache
+	localStats tierStats
+	redisStats tierStats
 }
 
 type User struct {
@@ -30,18 +72,62 @@ type User struct {
 }
 
 func NewUsersManager(r *redis_gateway.Client, pg *pg_gateway.Client, reg *metrics.Registry, cacheTTL time.Duration) *UsersManager {
+	var c cache
+	if r != nil {
+		c = r
+	}
+	return newUsersManager(c, pg, reg, cacheTTL)
+}
+
+func newUsersManager(c cache, pg store, reg *metrics.Registry, cacheTTL time.Duration) *UsersManager {
 	if cacheTTL == 0 {
 		cacheTTL = 10 * time.Minute
 	}
-	return &UsersManager{
-		redis:    r,
-		pg:       pg,
-		metrics:  reg,
-		cacheTTL: cacheTTL,
+	u := &UsersManager{
+		redis:   c,
+		pg:      pg,
+		metrics: reg,
+	}
+	u.cacheTTL.Store(int64(cacheTTL))
+	u.SetEarlyExpiration(1)
+	return u
+}
+
+// SetCacheTTL changes the TTL used for users cached from now on.
+func (u *UsersManager) SetCacheTTL(ttl time.Duration) {
+	if ttl > 0 {
+		u.cacheTTL.Store(int64(ttl))
 	}
 }
-func (u *UsersManager) CreateUser(ctx context.Context, first, last string, age int, marital bool) (string, error) {
-	userID := uuid.NewString()
+
+// SetStaleWhileRevalidate keeps cached users for stale past their TTL.
+// GetUser returns such an entry at once and refreshes it in the
+// background. 0, the default, disables it.
+func (u *UsersManager) SetStaleWhileRevalidate(stale time.Duration) {
+	if stale >= 0 {
+		u.staleTTL.Store(int64(stale))
+	}
+}
+
+// SetEarlyExpiration sets how eagerly GetUser refreshes an entry before it
+// expires (see cacheEntry.expiresEarly). 1, the default, suits most keys;
+// larger values refresh earlier and 0 disables it.
+func (u *UsersManager) SetEarlyExpiration(beta float64) {
+	if beta >= 0 {
+		u.earlyBeta.Store(math.Float64bits(beta))
+	}
+}
+func (u *UsersManager) CreateUser(ctx context.Context, first, last string, age int, marital bool) (userID string, err error) {
+	ctx, span := tracing.Start(ctx, "UsersManager.CreateUser", trace.SpanKindInternal)
+	defer func() { tracing.End(span, err) }()
+
+	if err := validate("users.CreateUser", first, last, age); err != nil {
+		u.inc("users_create_total", map[string]string{"status": "invalid", "code": apperr.Code(err)})
+		return "", correlation.Annotate(ctx, err)
+	}
+
+	userID = uuid.NewString()
+	span.SetAttributes(attribute.String("user.id", userID))
 	user := User{
 		UserID:        userID,
 		FirstName:     first,
@@ -51,31 +137,102 @@ func (u *UsersManager) CreateUser(ctx context.Context, first, last string, age i
 	}
 	dataBytes, err := json.Marshal(user)
 	if err != nil {
-		u.inc("users_create_total", map[string]string{"status": "marshal_error"})
-		return "", fmt.Errorf("marshal user: %w", err)
+		u.inc("users_create_total", map[string]string{"status": "marshal_error", "code": apperr.Internal.Code()})
+		return "", correlation.Annotate(ctx, apperr.E(apperr.Internal, "marshal user", err))
 	}
 	jsonStr := string(dataBytes)
 
-	
-	if err := u.pg.SaveUser(ctx, userID, jsonStr); err != nil {
-		u.inc("users_create_total", map[string]string{"status": "pg_error"})
-		return "", err
+	saveStart := time.Now()
+	created, err := u.pg.SaveUser(ctx, userID, jsonStr)
+	if err != nil {
+		u.inc("users_create_total", map[string]string{"status": "pg_error", "code": apperr.Code(err)})
+		return "", correlation.Annotate(ctx, err)
 	}
 	if u.redis != nil {
-		if err := u.redis.Set(ctx, "user:"+userID, jsonStr, u.cacheTTL); err != nil {
-			u.inc("users_cache_set_total", map[string]string{"status": "error"})
-		} else {
-			u.inc("users_cache_set_total", map[string]string{"status": "success"})
-		}
+		// Caching is best effort: while Redis is degraded or its breaker
+		// is open the write is skipped and the user is still created. The
+		// save's duration stands in for a load's until the first refresh.
+		u.countCacheSet(u.store(ctx, userID, dataBytes, time.Since(saveStart)))
 	}
-	u.inc("users_create_total", map[string]string{"status": "success"})
+	// A new row cannot be cached anywhere yet; only an upsert that
+	// replaced one has copies to evict.
+	if !created {
+		u.InvalidateUser(ctx, userID)
+	}
+	u.inc("users_create_total", map[string]string{"status": "success", "code": apperr.Code(nil)})
 	return userID, nil
 }
-func (u *UsersManager) GetUsers(ctx context.Context) ([]User, error) {
+
+// UpdateUser replaces an existing user's fields, refreshes its cached copy
+// and evicts it from every replica's local cache.
+func (u *UsersManager) UpdateUser(ctx context.Context, userID, first, last string, age int, marital bool) (_ *User, err error) {
+	ctx, span := tracing.Start(ctx, "UsersManager.UpdateUser", trace.SpanKindInternal, attribute.String("user.id", userID))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validate("users.UpdateUser", first, last, age); err != nil {
+		u.inc("users_update_total", map[string]string{"status": "invalid", "code": apperr.Code(err)})
+		return nil, correlation.Annotate(ctx, err)
+	}
+	user := User{
+		UserID:        userID,
+		FirstName:     first,
+		LastName:      last,
+		Age:           age,
+		MaritalStatus: marital,
+	}
+	dataBytes, err := json.Marshal(user)
+	if err != nil {
+		u.inc("users_update_total", map[string]string{"status": "marshal_error", "code": apperr.Internal.Code()})
+		return nil, correlation.Annotate(ctx, apperr.E(apperr.Internal, "marshal user", err))
+	}
+
+	saveStart := time.Now()
+	if err := u.pg.UpdateUser(ctx, userID, string(dataBytes)); err != nil {
+		u.inc("users_update_total", map[string]string{"status": "pg_error", "code": apperr.Code(err)})
+		return nil, correlation.Annotate(ctx, err)
+	}
+	if u.redis != nil {
+		u.countCacheSet(u.store(ctx, userID, dataBytes, time.Since(saveStart)))
+	}
+	u.InvalidateUser(ctx, userID)
+	u.inc("users_update_total", map[string]string{"status": "success", "code": apperr.Code(nil)})
+	return &user, nil
+}
+
+// DeleteUser removes a user and its cached copies.
+func (u *UsersManager) DeleteUser(ctx context.Context, userID string) (err error) {
+	ctx, span := tracing.Start(ctx, "UsersManager.DeleteUser", trace.SpanKindInternal, attribute.String("user.id", userID))
+	defer func() { tracing.End(span, err) }()
+
+	if err := u.pg.DeleteUser(ctx, userID); err != nil {
+		u.inc("users_delete_total", map[string]string{"status": "pg_error", "code": apperr.Code(err)})
+		return correlation.Annotate(ctx, err)
+	}
+	if u.redis != nil {
+		// Best effort like the writes: a copy left behind is served until
+		// its TTL.
+		status := "success"
+		switch err := u.redis.Del(ctx, cacheKey(userID)); {
+		case errors.Is(err, apperr.Unavailable):
+			status = "skipped"
+		case err != nil:
+			status = "error"
+		}
+		u.inc("users_cache_del_total", map[string]string{"status": status})
+	}
+	u.InvalidateUser(ctx, userID)
+	u.inc("users_delete_total", map[string]string{"status": "success", "code": apperr.Code(nil)})
+	return nil
+}
+
+func (u *UsersManager) GetUsers(ctx context.Context) (_ []User, err error) {
+	ctx, span := tracing.Start(ctx, "UsersManager.GetUsers", trace.SpanKindInternal)
+	defer func() { tracing.End(span, err) }()
+
 	dbUsers, err := u.pg.GetUsers(ctx)
 	if err != nil {
-		u.inc("users_get_total", map[string]string{"status": "pg_error"})
-		return nil, err
+		u.inc("users_get_total", map[string]string{"status": "pg_error", "code": apperr.Code(err)})
+		return nil, correlation.Annotate(ctx, err)
 	}
 	out := make([]User, 0, len(dbUsers))
 	unmarshalErrors := 0
@@ -90,9 +247,153 @@ func (u *UsersManager) GetUsers(ctx context.Context) ([]User, error) {
 	if unmarshalErrors > 0 {
 		u.inc("users_unmarshal_error_total", map[string]string{"count": fmt.Sprintf("%d", unmarshalErrors)})
 	}
-	u.inc("users_get_total", map[string]string{"status": "success"})
+	u.inc("users_get_total", map[string]string{"status": "success", "code": apperr.Code(nil)})
 	return out, nil
 }
+
+// GetUser returns one user, reading through the Redis cache. Concurrent
+// misses for the same user share one Postgres query; entries close to
+// expiry may be refreshed early, and with stale-while-revalidate an
+// expired entry is returned while a background load replaces it.
+func (u *UsersManager) GetUser(ctx context.Context, userID string) (_ *User, err error) {
+	ctx, span := tracing.Start(ctx, "UsersManager.GetUser", trace.SpanKindInternal, attribute.String("user.id", userID))
+	defer func() { tracing.End(span, err) }()
+
+	var gen uint64
+	if u.local != nil {
+		gen = u.local.generation()
+		usr, ok := u.local.get(userID, time.Now())
+		u.tier("local", &u.localStats, ok)
+		if ok {
+			span.SetAttributes(attribute.String("cache.result", "hit"), attribute.String("cache.tier", "local"))
+			return &usr, nil
+		}
+	}
+
+	entry, usr, result := u.cached(ctx, userID)
+	serve := result == "hit"
+	if serve {
+		now := time.Now()
+		switch {
+		case !now.Before(entry.freshUntil()):
+			result = "expired"
+		case entry.expiresEarly(now, math.Float64frombits(u.earlyBeta.Load())):
+			result = "early_expired"
+		}
+		// With stale-while-revalidate this reader gets what we have and a
+		// later one the refreshed copy; without it the entry is reloaded
+		// in line.
+		if result != "hit" {
+			serve = u.staleTTL.Load() > 0
+			if serve {
+				u.refresh(ctx, userID)
+			}
+		}
+	}
+	span.SetAttributes(attribute.String("cache.result", result), attribute.Bool("cache.served", serve))
+	u.inc("users_cache_get_total", map[string]string{"result": result, "served": strconv.FormatBool(serve)})
+	u.tier("redis", &u.redisStats, serve)
+	if serve {
+		// Only fresh entries are promoted, and only until they expire.
+		if u.local != nil && result == "hit" {
+			now := time.Now()
+			u.local.put(userID, usr, len(entry.User), entry.freshUntil().Sub(now), gen, now)
+			u.setLocalGauges()
+		}
+		return &usr, nil
+	}
+	return u.load(ctx, userID)
+}
+
+// load reads userID from Postgres and caches it. Callers loading the same
+// user at the same time share one query; the query is not cancelled when
+// one of them gives up.
+func (u *UsersManager) load(ctx context.Context, userID string) (*User, error) {
+	detached := context.WithoutCancel(ctx)
+	usr, shared, err := u.loads.do(ctx, userID, func() (User, error) {
+		return u.loadUser(detached, userID)
+	})
+	u.inc("users_load_total", map[string]string{"shared": strconv.FormatBool(shared), "code": apperr.Code(err)})
+	if err != nil {
+		return nil, correlation.Annotate(ctx, err)
+	}
+	return &usr, nil
+}
+
+// refresh reloads userID in the background, unless a load is already
+// running.
+func (u *UsersManager) refresh(ctx context.Context, userID string) {
+	detached := context.WithoutCancel(ctx)
+	go func() {
+		_, shared, err := u.loads.do(detached, userID, func() (User, error) {
+			return u.loadUser(detached, userID)
+		})
+		if shared {
+			return
+		}
+		status := "success"
+		if err != nil {
+			status = "error"
+		}
+		u.inc("users_cache_refresh_total", map[string]string{"status": status, "code": apperr.Code(err)})
+	}()
+}
+
+func (u *UsersManager) loadUser(ctx context.Context, userID string) (User, error) {
+	var gen uint64
+	if u.local != nil {
+		gen = u.local.generation()
+	}
+	start := time.Now()
+	stored, err := u.pg.GetUser(ctx, userID)
+	if err != nil {
+		return User{}, err
+	}
+	var usr User
+	if err := json.Unmarshal([]byte(stored.Data), &usr); err != nil {
+		return User{}, apperr.E(apperr.Internal, "unmarshal user", err)
+	}
+	if u.redis != nil {
+		u.countCacheSet(u.store(ctx, userID, []byte(stored.Data), time.Since(start)))
+	}
+	if u.local != nil {
+		u.local.put(userID, usr, len(stored.Data), 0, gen, time.Now())
+		u.setLocalGauges()
+	}
+	return usr, nil
+}
+
+// countCacheSet records the outcome of a best-effort cache write.
+func (u *UsersManager) countCacheSet(err error) {
+	switch {
+	case errors.Is(err, apperr.Unavailable):
+		u.inc("users_cache_set_total", map[string]string{"status": "skipped"})
+	case err != nil:
+		u.inc("users_cache_set_total", map[string]string{"status": "error"})
+	default:
+		u.inc("users_cache_set_total", map[string]string{"status": "success"})
+	}
+}
+
+// validate rejects users that could never be stored meaningfully, so the
+// worker can drop the message instead of requeueing it.
+func validate(op, first, last string, age int) error {
+	var problems []string
+	if strings.TrimSpace(first) == "" {
+		problems = append(problems, "first_name is required")
+	}
+	if strings.TrimSpace(last) == "" {
+		problems = append(problems, "last_name is required")
+	}
+	if age < 0 {
+		problems = append(problems, "age must not be negative")
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &apperr.Error{Kind: apperr.Validation, Op: op, Msg: strings.Join(problems, "; ")}
+}
+
 func (u *UsersManager) inc(name string, labels map[string]string) {
 	if u.metrics == nil {
 		return
@@ -0,0 +1,422 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api/internal/apperr"
+	"api/internal/pg_gateway"
+)
+
+var errMiss = apperr.New(apperr.NotFound, "miss")
+
+// fakeCache is an in-memory cache; it ignores TTLs. Publishes reach the
+// subscribers on bus, if set.
+type fakeCache struct {
+	mu        sync.Mutex
+	data      map[string]string
+	gets      atomic.Int64
+	publishes atomic.Int64
+	sets      chan string // receives keys as they are set, if non-nil
+	bus       *fakeBus
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{data: make(map[string]string)}
+}
+
+func (c *fakeCache) Get(_ context.Context, key string) (string, error) {
+	c.gets.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.data[key]
+	if !ok {
+		return "", errMiss
+	}
+	return v, nil
+}
+
+func (c *fakeCache) Set(_ context.Context, key, value string, _ time.Duration) error {
+	c.mu.Lock()
+	c.data[key] = value
+	c.mu.Unlock()
+	if c.sets != nil {
+		c.sets <- key
+	}
+	return nil
+}
+
+func (c *fakeCache) Del(_ context.Context, key string) error {
+	c.mu.Lock()
+	delete(c.data, key)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *fakeCache) Publish(_ context.Context, channel, payload string) error {
+	c.publishes.Add(1)
+	if c.bus != nil {
+		c.bus.publish(channel, payload)
+	}
+	return nil
+}
+
+func (c *fakeCache) Subscribe(ctx context.Context, channel string, handle func(string), onSubscribe func()) {
+	if c.bus == nil {
+		return
+	}
+	c.bus.subscribe(channel, handle)
+	if onSubscribe != nil {
+		onSubscribe()
+	}
+	<-ctx.Done()
+}
+
+// fakeBus delivers published messages synchronously, like a pub/sub
+// channel shared by the replicas' Redis clients.
+type fakeBus struct {
+	mu   sync.Mutex
+	subs map[string][]func(string)
+}
+
+func (b *fakeBus) subscribe(channel string, handle func(string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[string][]func(string))
+	}
+	b.subs[channel] = append(b.subs[channel], handle)
+}
+
+func (b *fakeBus) publish(channel, payload string) {
+	b.mu.Lock()
+	subs := make([]func(string), len(b.subs[channel]))
+	copy(subs, b.subs[channel])
+	b.mu.Unlock()
+	for _, handle := range subs {
+		handle(payload)
+	}
+}
+
+func (b *fakeBus) subscribers(channel string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs[channel])
+}
+
+// put caches usr with the given freshness and load time.
+func (c *fakeCache) put(t *testing.T, usr User, freshUntil time.Time, delta time.Duration) {
+	t.Helper()
+	data, _ := json.Marshal(usr)
+	entry, err := json.Marshal(cacheEntry{User: data, FreshUntil: freshUntil.UnixMilli(), Delta: delta.Milliseconds()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.data[cacheKey(usr.UserID)] = string(entry)
+}
+
+// fakeStore serves users from a map. While gate is non-nil GetUser blocks
+// until it is closed. With upsertOnly SaveUser reports every row as an
+// update, as for an ID that was already taken.
+type fakeStore struct {
+	mu         sync.Mutex
+	users      map[string]User
+	gate       chan struct{}
+	reads      atomic.Int64
+	upsertOnly bool
+}
+
+func (s *fakeStore) SaveUser(_ context.Context, userID string, jsonData string) (bool, error) {
+	var usr User
+	if err := json.Unmarshal([]byte(jsonData), &usr); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users == nil {
+		s.users = make(map[string]User)
+	}
+	_, existed := s.users[userID]
+	s.users[userID] = usr
+	return !existed && !s.upsertOnly, nil
+}
+
+func (s *fakeStore) UpdateUser(_ context.Context, userID string, jsonData string) error {
+	var usr User
+	if err := json.Unmarshal([]byte(jsonData), &usr); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userID]; !ok {
+		return pg_gateway.ErrUserNotFound
+	}
+	s.users[userID] = usr
+	return nil
+}
+
+func (s *fakeStore) DeleteUser(_ context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userID]; !ok {
+		return pg_gateway.ErrUserNotFound
+	}
+	delete(s.users, userID)
+	return nil
+}
+
+func (s *fakeStore) GetUsers(context.Context) ([]pg_gateway.StoredUser, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) GetUser(ctx context.Context, userID string) (*pg_gateway.StoredUser, error) {
+	s.reads.Add(1)
+	if s.gate != nil {
+		select {
+		case <-s.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	usr, ok := s.users[userID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, pg_gateway.ErrUserNotFound
+	}
+	data, _ := json.Marshal(usr)
+	return &pg_gateway.StoredUser{UserID: userID, Data: string(data)}, nil
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fixUniform makes expiresEarly draw r for the rest of the test.
+func fixUniform(t *testing.T, r float64) {
+	old := uniform
+	uniform = func() float64 { return r }
+	t.Cleanup(func() { uniform = old })
+}
+
+func TestGetUserCoalescesMisses(t *testing.T) {
+	const n = 20
+	st := &fakeStore{users: map[string]User{"u1": {UserID: "u1", FirstName: "Ada"}}, gate: make(chan struct{})}
+	c := newFakeCache()
+	u := newUsersManager(c, st, nil, time.Minute)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			usr, err := u.GetUser(context.Background(), "u1")
+			if err == nil && usr.FirstName != "Ada" {
+				t.Errorf("GetUser = %+v", usr)
+			}
+			errs <- err
+		}()
+	}
+	// Every caller has missed the cache; give the last ones time to join
+	// the load before letting it finish.
+	waitFor(t, "all cache lookups", func() bool { return c.gets.Load() == n })
+	time.Sleep(20 * time.Millisecond)
+	close(st.gate)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+	}
+	if got := st.reads.Load(); got != 1 {
+		t.Errorf("%d concurrent misses ran %d queries, want 1", n, got)
+	}
+	if _, ok := c.data[cacheKey("u1")]; !ok {
+		t.Error("loaded user was not cached")
+	}
+}
+
+func TestGetUserNotFound(t *testing.T) {
+	u := newUsersManager(newFakeCache(), &fakeStore{}, nil, time.Minute)
+	if _, err := u.GetUser(context.Background(), "nope"); apperr.KindOf(err) != apperr.NotFound {
+		t.Fatalf("GetUser error = %v, want NotFound", err)
+	}
+}
+
+func TestGetUserServesStaleWhileRefreshing(t *testing.T) {
+	old := User{UserID: "u1", FirstName: "Old"}
+	st := &fakeStore{users: map[string]User{"u1": {UserID: "u1", FirstName: "New"}}, gate: make(chan struct{})}
+	c := newFakeCache()
+	c.put(t, old, time.Now().Add(-time.Second), 10*time.Millisecond)
+	c.sets = make(chan string, 1)
+	u := newUsersManager(c, st, nil, time.Minute)
+	u.SetStaleWhileRevalidate(time.Minute)
+
+	usr, err := u.GetUser(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usr.FirstName != "Old" {
+		t.Fatalf("GetUser = %+v, want the stale copy at once", usr)
+	}
+	waitFor(t, "background refresh", func() bool { return st.reads.Load() == 1 })
+	close(st.gate)
+	select {
+	case <-c.sets:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh did not update the cache")
+	}
+
+	usr, err = u.GetUser(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usr.FirstName != "New" {
+		t.Errorf("GetUser after refresh = %+v, want the refreshed copy", usr)
+	}
+	if got := st.reads.Load(); got != 1 {
+		t.Errorf("ran %d queries, want 1", got)
+	}
+}
+
+func TestGetUserReloadsExpiredWithoutStale(t *testing.T) {
+	st := &fakeStore{users: map[string]User{"u1": {UserID: "u1", FirstName: "New"}}}
+	c := newFakeCache()
+	c.put(t, User{UserID: "u1", FirstName: "Old"}, time.Now().Add(-time.Second), 10*time.Millisecond)
+	u := newUsersManager(c, st, nil, time.Minute)
+
+	usr, err := u.GetUser(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usr.FirstName != "New" || st.reads.Load() != 1 {
+		t.Errorf("GetUser = %+v after %d queries, want the reloaded copy after 1", usr, st.reads.Load())
+	}
+}
+
+func TestExpiresEarly(t *testing.T) {
+	// 1-1/e makes -ln(1-r) exactly 1, so the head start is delta*beta.
+	fixUniform(t, 1-1/math.E)
+	now := time.Now()
+	delta := 100 * time.Millisecond
+	entry := func(left time.Duration) cacheEntry {
+		return cacheEntry{FreshUntil: now.Add(left).UnixMilli(), Delta: delta.Milliseconds()}
+	}
+
+	tests := []struct {
+		name string
+		left time.Duration
+		beta float64
+		want bool
+	}{
+		{"head start reaches expiry", 150 * time.Millisecond, 2, true},
+		{"head start short of expiry", 250 * time.Millisecond, 2, false},
+		{"beta scales the head start", 150 * time.Millisecond, 1, false},
+		{"beta 0 disables it", 10 * time.Millisecond, 0, false},
+		{"already expired", -time.Millisecond, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entry(tt.left).expiresEarly(now, tt.beta); got != tt.want {
+				t.Errorf("expiresEarly = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	noDelta := cacheEntry{FreshUntil: now.Add(time.Millisecond).UnixMilli()}
+	if noDelta.expiresEarly(now, 10) {
+		t.Error("entry without a load time expired early")
+	}
+}
+
+func TestGetUserRefreshesEarly(t *testing.T) {
+	fixUniform(t, 1-1/math.E)
+	st := &fakeStore{users: map[string]User{"u1": {UserID: "u1", FirstName: "New"}}}
+	c := newFakeCache()
+	// Fresh for another 150ms, but a 100ms load at beta 2 starts 200ms
+	// ahead.
+	c.put(t, User{UserID: "u1", FirstName: "Old"}, time.Now().Add(150*time.Millisecond), 100*time.Millisecond)
+	u := newUsersManager(c, st, nil, time.Minute)
+	u.SetEarlyExpiration(2)
+
+	usr, err := u.GetUser(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usr.FirstName != "New" || st.reads.Load() != 1 {
+		t.Errorf("GetUser = %+v after %d queries, want an early reload", usr, st.reads.Load())
+	}
+
+	// Far from expiry the same draw leaves the entry alone.
+	c.put(t, User{UserID: "u1", FirstName: "Cached"}, time.Now().Add(time.Hour), 100*time.Millisecond)
+	usr, err = u.GetUser(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usr.FirstName != "Cached" || st.reads.Load() != 1 {
+		t.Errorf("GetUser = %+v after %d queries, want the cached copy", usr, st.reads.Load())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	st := &fakeStore{users: map[string]User{"u1": {UserID: "u1", FirstName: "Ada"}}}
+	h := NewHandler(newUsersManager(newFakeCache(), st, nil, time.Minute))
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodGet, "/users/u1", "", http.StatusOK},
+		{http.MethodGet, "/users/missing", "", http.StatusNotFound},
+		{http.MethodGet, "/users/", "", http.StatusNotFound},
+		{http.MethodPost, "/users/u1", "", http.StatusMethodNotAllowed},
+		{http.MethodPut, "/users/u1", `{"first_name":"Ada","last_name":"King","age":37}`, http.StatusOK},
+		{http.MethodPut, "/users/u1", `{"first_name":"","last_name":"King"}`, http.StatusUnprocessableEntity},
+		{http.MethodPut, "/users/u1", `not json`, http.StatusUnprocessableEntity},
+		{http.MethodPut, "/users/missing", `{"first_name":"A","last_name":"B"}`, http.StatusNotFound},
+		{http.MethodDelete, "/users/missing", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/u1", nil))
+	var usr User
+	if err := json.NewDecoder(rec.Body).Decode(&usr); err != nil || usr.LastName != "King" {
+		t.Errorf("GET /users/u1 body = %+v, %v, want the updated user", usr, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/users/u1", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE /users/u1 = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/u1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /users/u1 after DELETE = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,354 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api/internal/correlation"
+	"api/internal/logging"
+	"api/internal/metrics"
+	"api/internal/tracing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Handler processes one delivery and is responsible for acking or nacking it.
+// ctx carries the delivery's correlation ID (see dispatch).
+type Handler func(ctx context.Context, d amqp.Delivery)
+
+type Config struct {
+	Queue       string
+	ConsumerTag string
+	// Prefetch bounds how many unacked deliveries the broker pushes to us,
+	// which is also how much work is buffered in memory while paused.
+	Prefetch int
+	// Concurrency is how many deliveries are handled at once. Defaults to
+	// 1; it can be changed while running with SetConcurrency.
+	Concurrency int
+	// HandlerTimeout bounds each handler call. Cancelling Run's ctx does
+	// not reach handlers, so shutdown lets them finish and ack instead of
+	// aborting them halfway. Defaults to 30s.
+	HandlerTimeout time.Duration
+
+	Logger *slog.Logger
+}
+
+// Consumer drives an AMQP consumer that can be paused by several
+// independent reasons (memory pressure, a dependency outage, ...). While
+// any reason is active the broker-side consumer is cancelled so no new
+// deliveries are buffered; it is re-registered once all reasons clear.
+type Consumer struct {
+	ch      *amqp.Channel
+	cfg     Config
+	handler Handler
+	metrics *metrics.Registry
+	log     *slog.Logger
+
+	mu      sync.Mutex
+	pauses  map[string]struct{}
+	changed chan struct{}
+
+	slots    *slots
+	inflight sync.WaitGroup
+	running  atomic.Bool
+
+	throttle func(ctx context.Context) error
+}
+
+func NewConsumer(ch *amqp.Channel, cfg Config, handler Handler) (*Consumer, error) {
+	if cfg.Queue == "" {
+		return nil, errors.New("worker: queue is required")
+	}
+	if cfg.ConsumerTag == "" {
+		cfg.ConsumerTag = "api-worker"
+	}
+	if cfg.Prefetch <= 0 {
+		cfg.Prefetch = 10
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.HandlerTimeout <= 0 {
+		cfg.HandlerTimeout = 30 * time.Second
+	}
+	if err := ch.Qos(cfg.Prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("worker: set qos: %w", err)
+	}
+	return &Consumer{
+		ch:      ch,
+		cfg:     cfg,
+		handler: handler,
+		log:     logging.Component(cfg.Logger, "worker"),
+		pauses:  make(map[string]struct{}),
+		changed: make(chan struct{}, 1),
+		slots:   newSlots(cfg.Concurrency),
+	}, nil
+}
+
+// SetConcurrency changes how many deliveries are handled at once. Handlers
+// already running are not interrupted; a lower limit takes effect as they
+// finish.
+func (c *Consumer) SetConcurrency(n int) {
+	if n <= 0 {
+		return
+	}
+	if n > c.cfg.Prefetch {
+		c.log.Warn("Concurrency exceeds prefetch; extra handlers will idle", "concurrency", n, "prefetch", c.cfg.Prefetch)
+	}
+	c.slots.setLimit(n)
+	if c.metrics != nil {
+		c.metrics.SetGauge("worker_concurrency", float64(n), nil)
+	}
+	c.log.Info("Concurrency changed", "concurrency", n)
+}
+
+func (c *Consumer) SetMetricsRegistry(reg *metrics.Registry) {
+	c.metrics = reg
+}
+
+// SetThrottle installs a wait run before each delivery is handled, e.g. a
+// rate limiter's. Deliveries stay unacked while it blocks, so prefetch
+// bounds the backlog. If it fails the delivery is requeued. Call it before
+// Run.
+func (c *Consumer) SetThrottle(wait func(ctx context.Context) error) {
+	c.throttle = wait
+}
+
+// Pause stops consumption for reason until Resume is called with the same
+// reason. It is safe to call repeatedly and from any goroutine.
+func (c *Consumer) Pause(reason string) {
+	c.mu.Lock()
+	_, already := c.pauses[reason]
+	c.pauses[reason] = struct{}{}
+	c.mu.Unlock()
+	if !already {
+		c.log.Warn("Pausing consumption", "reason", reason)
+		c.notify()
+	}
+}
+
+func (c *Consumer) Resume(reason string) {
+	c.mu.Lock()
+	_, had := c.pauses[reason]
+	delete(c.pauses, reason)
+	c.mu.Unlock()
+	if had {
+		c.log.Info("Clearing pause reason", "reason", reason)
+		c.notify()
+	}
+}
+
+func (c *Consumer) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pauses) > 0
+}
+
+// PauseReasons returns the active pause reasons, sorted.
+func (c *Consumer) PauseReasons() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]string, 0, len(c.pauses))
+	for r := range c.pauses {
+		out = append(out, r)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (c *Consumer) notify() {
+	c.setGauge()
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Consumer) setGauge() {
+	if c.metrics == nil {
+		return
+	}
+	v := 0.0
+	if c.Paused() {
+		v = 1
+	}
+	c.metrics.SetGauge("worker_consumer_paused", v, nil)
+}
+
+// Check reports whether the consumer can still receive deliveries. A
+// deliberate pause is not a failure; a closed channel or a Run that has
+// returned is.
+func (c *Consumer) Check(context.Context) error {
+	if c.ch.IsClosed() {
+		return errors.New("amqp channel closed")
+	}
+	if !c.running.Load() {
+		return errors.New("consumer not running")
+	}
+	return nil
+}
+
+// Run consumes until ctx is cancelled or the channel closes.
+func (c *Consumer) Run(ctx context.Context) error {
+	c.running.Store(true)
+	defer c.running.Store(false)
+	c.setGauge()
+	for {
+		if err := c.waitUnpaused(ctx); err != nil {
+			c.inflight.Wait()
+			return nil
+		}
+
+		msgs, err := c.ch.Consume(c.cfg.Queue, c.cfg.ConsumerTag, false, false, false, false, nil)
+		if err != nil {
+			return fmt.Errorf("worker: register consumer: %w", err)
+		}
+		c.log.Info("Consuming messages", "queue", c.cfg.Queue)
+
+		stop, err := c.consume(ctx, msgs)
+		if err != nil || stop {
+			// Handlers may still be acking; the caller closes the channel
+			// as soon as Run returns.
+			c.inflight.Wait()
+			return err
+		}
+	}
+}
+
+func (c *Consumer) waitUnpaused(ctx context.Context) error {
+	for c.Paused() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.changed:
+		}
+	}
+	return nil
+}
+
+// consume handles deliveries until a pause or shutdown. It reports stop=true
+// when Run should return.
+func (c *Consumer) consume(ctx context.Context, msgs <-chan amqp.Delivery) (bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			c.cancel(ctx, msgs)
+			return true, nil
+		case <-c.changed:
+			if c.Paused() {
+				c.log.Warn("Consumer paused", "reasons", strings.Join(c.PauseReasons(), ","))
+				c.cancel(ctx, msgs)
+				return false, nil
+			}
+		case d, ok := <-msgs:
+			if !ok {
+				return true, errors.New("worker: delivery channel closed")
+			}
+			c.start(ctx, d)
+		}
+	}
+}
+
+// cancel stops broker deliveries and settles whatever was already pushed to
+// us, so nothing sits unacked while paused. On shutdown the leftovers are
+// requeued instead of processed.
+func (c *Consumer) cancel(ctx context.Context, msgs <-chan amqp.Delivery) {
+	if err := c.ch.Cancel(c.cfg.ConsumerTag, false); err != nil {
+		c.log.Error("Failed to cancel consumer", "error", err)
+		return
+	}
+	for d := range msgs {
+		if ctx.Err() != nil {
+			_ = d.Nack(false, true)
+			continue
+		}
+		c.start(ctx, d)
+	}
+}
+
+// start waits for the throttle and a free slot and handles d in its own
+// goroutine.
+func (c *Consumer) start(ctx context.Context, d amqp.Delivery) {
+	if c.throttle != nil {
+		if err := c.throttle(ctx); err != nil {
+			_ = d.Nack(false, true)
+			return
+		}
+	}
+	c.slots.acquire()
+	c.inflight.Add(1)
+	go func() {
+		defer c.inflight.Done()
+		defer c.slots.release()
+		c.dispatch(ctx, d)
+	}()
+}
+
+// dispatch runs the handler, bounded by HandlerTimeout, with the delivery's
+// CorrelationId, falling back to its MessageId or a generated ID, attached
+// to ctx, inside a consumer span continuing any traceparent found in the
+// message headers.
+func (c *Consumer) dispatch(ctx context.Context, d amqp.Delivery) {
+	// Cancelling Run only stops consumption; a handler already running,
+	// e.g. one whose write has reached Postgres, gets to ack its delivery
+	// rather than have it requeued and processed twice.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.cfg.HandlerTimeout)
+	defer cancel()
+	ctx, id := correlation.Ensure(ctx, d.CorrelationId, d.MessageId)
+	ctx = tracing.ExtractAMQP(ctx, d.Headers)
+	ctx, span := tracing.Start(ctx, c.cfg.Queue+" process", trace.SpanKindConsumer,
+		attribute.String("messaging.system", "rabbitmq"),
+		attribute.String("messaging.destination.name", c.cfg.Queue),
+		attribute.String("messaging.message.id", d.MessageId),
+		attribute.String("request_id", id),
+		attribute.Bool("messaging.rabbitmq.redelivered", d.Redelivered),
+	)
+	defer span.End()
+	c.handler(ctx, d)
+}
+
+// slots is a counting semaphore whose limit can change at runtime.
+type slots struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+func newSlots(limit int) *slots {
+	s := &slots{limit: limit}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *slots) acquire() {
+	s.mu.Lock()
+	for s.active >= s.limit {
+		s.cond.Wait()
+	}
+	s.active++
+	s.mu.Unlock()
+}
+
+func (s *slots) release() {
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+	s.cond.Signal()
+}
+
+func (s *slots) setLimit(n int) {
+	s.mu.Lock()
+	s.limit = n
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}